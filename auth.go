@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// ログインが必要なAPIの認証
+// ルートに requireLogin を付けておけば、ハンドラは currentUser でログイン中のユーザを取り出すだけでよい
+
+const principalContextKey = "principal"
+
+// Principal はリクエストしたユーザとそのセッション
+type Principal struct {
+	User
+	SessionID string
+}
+
+// requireLogin はセッションを検証し、ログイン中のユーザを echo.Context に入れるミドルウェア
+func requireLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := verifyUserSession(c); err != nil {
+			// echo.NewHTTPErrorが返っているのでそのまま出力
+			return err
+		}
+
+		// error already checked
+		sess, _ := session.Get(defaultSessionIDKey, c)
+		// existence already checked
+		userID := sess.Values[defaultUserIDKey].(int64)
+		sessionID := sess.Values[defaultSessionIDKey].(string)
+
+		user, err := getUserResponse(c.Request().Context(), nil, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "not found user that has the session")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		c.Set(principalContextKey, &Principal{User: user, SessionID: sessionID})
+		return next(c)
+	}
+}
+
+// currentUser は requireLogin が入れたログイン中のユーザ
+// requireLogin を通っていないルートで呼ばれた場合は 401 を返す
+func currentUser(c echo.Context) (*Principal, error) {
+	principal, ok := c.Get(principalContextKey).(*Principal)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "login is required")
+	}
+	return principal, nil
+}
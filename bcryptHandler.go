@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
@@ -13,19 +15,35 @@ type PostBcryptCompairHandler struct {
 
 type PostBcryptSumHandler struct {
 	Password string `json:"password"`
+	// Cost が 0 なら bcrypt.DefaultCost
+	Cost int `json:"cost,omitempty"`
 }
 
 type PostBcryptSumResult struct {
 	HashedPassword string `json:"hashed_password"`
 }
 
+// パスワード照合API (他のノードから使う)
+// POST /api/bcrypt/compair
 func bcryptCompairHandler(c echo.Context) error {
+	if err := verifyBcryptSecret(c); err != nil {
+		return err
+	}
+
 	req := new(PostBcryptCompairHandler)
 	if err := c.Bind(req); err != nil {
 		return err
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(req.HashedPassword), []byte(req.Password))
+	var err error
+	if perr := bcryptWorkerPool.Do(c.Request().Context(), func() {
+		err = bcrypt.CompareHashAndPassword([]byte(req.HashedPassword), []byte(req.Password))
+	}); perr != nil {
+		if errors.Is(perr, errBcryptQueueFull) {
+			return bcryptUnavailableError(c)
+		}
+		return perr
+	}
 	if err != nil {
 		if err == bcrypt.ErrMismatchedHashAndPassword {
 			return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
@@ -36,13 +54,36 @@ func bcryptCompairHandler(c echo.Context) error {
 	return c.NoContent(200)
 }
 
+// パスワードハッシュ計算API (他のノードから使う)
+// POST /api/bcrypt/sum
 func bcryptSumHandler(c echo.Context) error {
+	if err := verifyBcryptSecret(c); err != nil {
+		return err
+	}
+
 	req := new(PostBcryptSumHandler)
 	if err := c.Bind(req); err != nil {
 		return err
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	cost := req.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid bcrypt cost")
+	}
+
+	var hashedPassword []byte
+	var err error
+	if perr := bcryptWorkerPool.Do(c.Request().Context(), func() {
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(req.Password), cost)
+	}); perr != nil {
+		if errors.Is(perr, errBcryptQueueFull) {
+			return bcryptUnavailableError(c)
+		}
+		return perr
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt の計算を他のノードの /api/bcrypt に任せるクライアント
+// 同時に投げるリクエスト数を制限し、どのノードからも応答がなければ自分で計算する
+
+const (
+	bcryptEndpointsEnvKey   = "ISUCON13_BCRYPT_ENDPOINTS"
+	bcryptSecretEnvKey      = "ISUCON13_BCRYPT_SECRET"
+	bcryptTimeoutEnvKey     = "ISUCON13_BCRYPT_TIMEOUT"
+	bcryptConcurrencyEnvKey = "ISUCON13_BCRYPT_CONCURRENCY"
+
+	bcryptSecretHeader = "X-Bcrypt-Secret"
+
+	defaultBcryptTimeout     = 3 * time.Second
+	defaultBcryptConcurrency = 64
+)
+
+var defaultBcryptEndpoint = fmt.Sprintf("http://192.168.0.13:%d/api/bcrypt", listenPort)
+
+var (
+	// /api/bcrypt の共有シークレット。未設定の場合は API を使わずに自分で計算する
+	bcryptSecret    []byte
+	bcryptAPIClient *bcryptClient
+)
+
+type bcryptClient struct {
+	endpoints []string
+	secret    []byte
+	timeout   time.Duration
+	client    *http.Client
+	// sem は同時に投げるリクエスト数の上限
+	sem  chan struct{}
+	next atomic.Uint64
+	// local はどのノードも使えないときに計算するワーカー
+	local *bcryptPool
+}
+
+func newBcryptClientFromEnv(local *bcryptPool) (*bcryptClient, error) {
+	endpoints := []string{defaultBcryptEndpoint}
+	if v, ok := os.LookupEnv(bcryptEndpointsEnvKey); ok {
+		endpoints = nil
+		for _, endpoint := range strings.Split(v, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+			}
+		}
+	}
+
+	timeout := defaultBcryptTimeout
+	if v, ok := os.LookupEnv(bcryptTimeoutEnvKey); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as positive duration: %s", bcryptTimeoutEnvKey, v)
+		}
+		timeout = d
+	}
+
+	concurrency := defaultBcryptConcurrency
+	if v, ok := os.LookupEnv(bcryptConcurrencyEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", bcryptConcurrencyEnvKey, v)
+		}
+		concurrency = n
+	}
+
+	if len(bcryptSecret) == 0 {
+		if len(endpoints) > 0 {
+			log.Printf("environ %s is not set. bcrypt is computed locally\n", bcryptSecretEnvKey)
+		}
+		endpoints = nil
+	}
+
+	return newBcryptClient(endpoints, bcryptSecret, timeout, concurrency, local), nil
+}
+
+func newBcryptClient(endpoints []string, secret []byte, timeout time.Duration, concurrency int, local *bcryptPool) *bcryptClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = concurrency * max(len(endpoints), 1)
+	transport.MaxIdleConnsPerHost = concurrency
+	transport.MaxConnsPerHost = concurrency
+	transport.IdleConnTimeout = 90 * time.Second
+
+	return &bcryptClient{
+		endpoints: endpoints,
+		secret:    secret,
+		timeout:   timeout,
+		client:    &http.Client{Transport: transport},
+		sem:       make(chan struct{}, concurrency),
+		local:     local,
+	}
+}
+
+// errBcryptEndpoint はそのノードが使えないことを表す (次のノードを試す)
+var errBcryptEndpoint = errors.New("bcrypt endpoint is unavailable")
+
+// Sum はパスワードのハッシュを返す
+// 自分で計算する場合に待ち行列が溢れていれば errBcryptQueueFull を返す
+func (c *bcryptClient) Sum(ctx context.Context, password string, cost int) (string, error) {
+	req := PostBcryptSumHandler{Password: password, Cost: cost}
+	for _, endpoint := range c.order() {
+		var res PostBcryptSumResult
+		err := c.post(ctx, endpoint+"/sum", req, &res)
+		if err == nil {
+			return res.HashedPassword, nil
+		}
+		if !errors.Is(err, errBcryptEndpoint) {
+			return "", err
+		}
+		log.Printf("failed to post to bcrypt api %s: %+v\n", endpoint, err)
+	}
+
+	var hashedPassword []byte
+	var err error
+	if perr := c.local.Do(ctx, func() {
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), cost)
+	}); perr != nil {
+		return "", perr
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// Compare はパスワードがハッシュと一致するか確かめる
+// 一致しない場合は bcrypt.ErrMismatchedHashAndPassword を返す
+func (c *bcryptClient) Compare(ctx context.Context, hashedPassword string, password string) error {
+	req := PostBcryptCompairHandler{Password: password, HashedPassword: hashedPassword}
+	for _, endpoint := range c.order() {
+		err := c.post(ctx, endpoint+"/compair", req, nil)
+		if err == nil || !errors.Is(err, errBcryptEndpoint) {
+			return err
+		}
+		log.Printf("failed to post to bcrypt api %s: %+v\n", endpoint, err)
+	}
+
+	var err error
+	if perr := c.local.Do(ctx, func() {
+		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// order は負荷が偏らないよう、呼ぶたびに先頭をずらしたノードの一覧を返す
+func (c *bcryptClient) order() []string {
+	if len(c.endpoints) == 0 {
+		return nil
+	}
+	start := int(c.next.Add(1) % uint64(len(c.endpoints)))
+	return append(c.endpoints[start:len(c.endpoints):len(c.endpoints)], c.endpoints[:start]...)
+}
+
+func (c *bcryptClient) post(ctx context.Context, url string, body any, res any) error {
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(bcryptSecretHeader, string(c.secret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBcryptEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusUnauthorized:
+		io.Copy(io.Discard, resp.Body)
+		return bcrypt.ErrMismatchedHashAndPassword
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: status %d: %s", errBcryptEndpoint, resp.StatusCode, msg)
+	}
+
+	if res == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("%w: %w", errBcryptEndpoint, err)
+	}
+	return nil
+}
+
+// verifyBcryptSecret は /api/bcrypt の共有シークレットを検証する
+func verifyBcryptSecret(c echo.Context) error {
+	secret := c.Request().Header.Get(bcryptSecretHeader)
+	if len(bcryptSecret) == 0 || subtle.ConstantTimeCompare([]byte(secret), bcryptSecret) != 1 {
+		return echo.NewHTTPError(http.StatusForbidden, "bcrypt secret is required")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testBcryptSecret = "test-bcrypt-secret"
+
+// bcryptStandIn は /api/bcrypt の代わりに応答するテスト用のノード
+type bcryptStandIn struct {
+	*httptest.Server
+	calls atomic.Int64
+	// status が 0 でなければ、計算せずにその状態コードを返す
+	status int
+	// secret は最後に受け取った共有シークレット
+	secret atomic.Value
+}
+
+func newBcryptStandIn(t *testing.T, status int) *bcryptStandIn {
+	t.Helper()
+
+	s := &bcryptStandIn{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *bcryptStandIn) serve(w http.ResponseWriter, r *http.Request) {
+	s.calls.Add(1)
+	s.secret.Store(r.Header.Get(bcryptSecretHeader))
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/bcrypt/compair":
+		var req PostBcryptCompairHandler
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(req.HashedPassword), []byte(req.Password)); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case "/api/bcrypt/sum":
+		var req PostBcryptSumHandler
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), req.Cost)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&PostBcryptSumResult{HashedPassword: string(hashedPassword)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *bcryptStandIn) endpoint() string {
+	return s.URL + "/api/bcrypt"
+}
+
+// downBcryptEndpoint は接続できないノードの URL
+func downBcryptEndpoint(t *testing.T) string {
+	t.Helper()
+
+	s := httptest.NewServer(http.NotFoundHandler())
+	endpoint := s.URL + "/api/bcrypt"
+	s.Close()
+	return endpoint
+}
+
+func newTestBcryptClient(endpoints []string) (*bcryptClient, *bcryptPool) {
+	local := newBcryptPool(1, 16)
+	return newBcryptClient(endpoints, []byte(testBcryptSecret), time.Second, 4, local), local
+}
+
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hashedPassword)
+}
+
+func TestBcryptClientSendsSecret(t *testing.T) {
+	node := newBcryptStandIn(t, 0)
+	c, _ := newTestBcryptClient([]string{node.endpoint()})
+
+	if err := c.Compare(context.Background(), testHash(t, "s3cret"), "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := node.secret.Load().(string); got != testBcryptSecret {
+		t.Errorf("%s = %q, want %q", bcryptSecretHeader, got, testBcryptSecret)
+	}
+}
+
+func TestBcryptClientFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		down func(t *testing.T) string
+	}{
+		{"connection refused", downBcryptEndpoint},
+		{"service unavailable", func(t *testing.T) string {
+			return newBcryptStandIn(t, http.StatusServiceUnavailable).endpoint()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newBcryptStandIn(t, 0)
+			c, local := newTestBcryptClient([]string{tt.down(t), node.endpoint()})
+			hashedPassword := testHash(t, "s3cret")
+
+			// 先頭のノードは呼ぶたびにずれるので、どちらが先でも生きているノードで計算する
+			for i := 0; i < 2; i++ {
+				if err := c.Compare(context.Background(), hashedPassword, "s3cret"); err != nil {
+					t.Fatalf("compare: %v", err)
+				}
+				sum, err := c.Sum(context.Background(), "s3cret", bcrypt.MinCost)
+				if err != nil {
+					t.Fatalf("sum: %v", err)
+				}
+				if err := bcrypt.CompareHashAndPassword([]byte(sum), []byte("s3cret")); err != nil {
+					t.Errorf("sum = %q: %v", sum, err)
+				}
+			}
+			if n := node.calls.Load(); n != 4 {
+				t.Errorf("live endpoint got %d calls, want 4", n)
+			}
+			if n := local.stats().Completed; n != 0 {
+				t.Errorf("computed %d times locally, want 0", n)
+			}
+		})
+	}
+}
+
+func TestBcryptClientFallsBackToLocal(t *testing.T) {
+	c, local := newTestBcryptClient([]string{
+		downBcryptEndpoint(t),
+		newBcryptStandIn(t, http.StatusInternalServerError).endpoint(),
+	})
+	hashedPassword := testHash(t, "s3cret")
+
+	if err := c.Compare(context.Background(), hashedPassword, "s3cret"); err != nil {
+		t.Errorf("compare: %v", err)
+	}
+	if err := c.Compare(context.Background(), hashedPassword, "wrong"); !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		t.Errorf("compare with wrong password = %v, want mismatch", err)
+	}
+	sum, err := c.Sum(context.Background(), "s3cret", bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("sum: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(sum), []byte("s3cret")); err != nil {
+		t.Errorf("sum = %q: %v", sum, err)
+	}
+	if n := local.stats().Completed; n != 3 {
+		t.Errorf("computed %d times locally, want 3", n)
+	}
+}
+
+func TestBcryptClientMismatch(t *testing.T) {
+	mismatch := newBcryptStandIn(t, http.StatusUnauthorized)
+	other := newBcryptStandIn(t, 0)
+	c, local := newTestBcryptClient([]string{mismatch.endpoint(), other.endpoint()})
+	// 先頭が mismatch になるようにずらしておく
+	c.next.Store(uint64(len(c.endpoints)) - 1)
+
+	err := c.Compare(context.Background(), testHash(t, "s3cret"), "wrong")
+	if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		t.Fatalf("compare = %v, want mismatch", err)
+	}
+	// 401 はノードの障害ではないので、他のノードにも自分でも計算し直さない
+	if n := other.calls.Load(); n != 0 {
+		t.Errorf("next endpoint got %d calls, want 0", n)
+	}
+	if n := local.stats().Completed; n != 0 {
+		t.Errorf("computed %d times locally, want 0", n)
+	}
+}
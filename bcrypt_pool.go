@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bcrypt の計算はCPUを使い切るので、決まった数のワーカーだけで計算する
+// 待ち行列が溢れたら 503 を返して、livecomment などのAPIの応答を守る
+
+const (
+	bcryptWorkersEnvKey   = "ISUCON13_BCRYPT_WORKERS"
+	bcryptQueueSizeEnvKey = "ISUCON13_BCRYPT_QUEUE_SIZE"
+
+	// 待ち行列が溢れたときに返す Retry-After (秒)
+	bcryptRetryAfter = 1
+)
+
+var errBcryptQueueFull = errors.New("bcrypt queue is full")
+
+var bcryptWorkerPool *bcryptPool
+
+type bcryptJob struct {
+	ctx      context.Context
+	fn       func()
+	enqueued time.Time
+	done     chan struct{}
+}
+
+type bcryptPool struct {
+	workers int
+	jobs    chan *bcryptJob
+
+	running   atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+	canceled  atomic.Int64
+	waitSum   atomic.Int64
+	waitMax   atomic.Int64
+}
+
+type BcryptPoolStats struct {
+	Workers       int   `json:"workers"`
+	QueueSize     int   `json:"queue_size"`
+	QueueDepth    int   `json:"queue_depth"`
+	Running       int64 `json:"running"`
+	Completed     int64 `json:"completed"`
+	Rejected      int64 `json:"rejected"`
+	Canceled      int64 `json:"canceled"`
+	WaitSumMicros int64 `json:"wait_sum_us"`
+	WaitMaxMicros int64 `json:"wait_max_us"`
+}
+
+func newBcryptPoolFromEnv() (*bcryptPool, error) {
+	workers := runtime.NumCPU()
+	if v, ok := os.LookupEnv(bcryptWorkersEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", bcryptWorkersEnvKey, v)
+		}
+		workers = n
+	}
+
+	queueSize := workers * 16
+	if v, ok := os.LookupEnv(bcryptQueueSizeEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as non-negative integer: %s", bcryptQueueSizeEnvKey, v)
+		}
+		queueSize = n
+	}
+
+	return newBcryptPool(workers, queueSize), nil
+}
+
+func newBcryptPool(workers int, queueSize int) *bcryptPool {
+	p := &bcryptPool{
+		workers: workers,
+		jobs:    make(chan *bcryptJob, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *bcryptPool) work() {
+	for job := range p.jobs {
+		// 待っている間に諦めたリクエストの分は計算しない
+		if job.ctx.Err() != nil {
+			p.canceled.Add(1)
+			close(job.done)
+			continue
+		}
+
+		wait := time.Since(job.enqueued).Microseconds()
+		p.waitSum.Add(wait)
+		for {
+			m := p.waitMax.Load()
+			if wait <= m || p.waitMax.CompareAndSwap(m, wait) {
+				break
+			}
+		}
+
+		p.running.Add(1)
+		job.fn()
+		p.running.Add(-1)
+		p.completed.Add(1)
+		close(job.done)
+	}
+}
+
+// Do は fn をワーカーで実行し、終わるまで待つ
+// 待ち行列が溢れている場合はすぐに errBcryptQueueFull を返す
+func (p *bcryptPool) Do(ctx context.Context, fn func()) error {
+	job := &bcryptJob{ctx: ctx, fn: fn, enqueued: time.Now(), done: make(chan struct{})}
+
+	select {
+	case p.jobs <- job:
+	default:
+		p.rejected.Add(1)
+		return errBcryptQueueFull
+	}
+
+	select {
+	case <-job.done:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *bcryptPool) stats() BcryptPoolStats {
+	return BcryptPoolStats{
+		Workers:       p.workers,
+		QueueSize:     cap(p.jobs),
+		QueueDepth:    len(p.jobs),
+		Running:       p.running.Load(),
+		Completed:     p.completed.Load(),
+		Rejected:      p.rejected.Load(),
+		Canceled:      p.canceled.Load(),
+		WaitSumMicros: p.waitSum.Load(),
+		WaitMaxMicros: p.waitMax.Load(),
+	}
+}
+
+// bcryptUnavailableError は待ち行列が溢れたときのエラー応答
+func bcryptUnavailableError(c echo.Context) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(bcryptRetryAfter))
+	return echo.NewHTTPError(http.StatusServiceUnavailable, errBcryptQueueFull.Error())
+}
+
+// bcrypt計算の待ち行列の状態
+// GET /api/bcrypt/stats
+func getBcryptStatsHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, bcryptWorkerPool.stats())
+}
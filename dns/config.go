@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AddrEnvKey             = "ISUCON13_DNS_ADDRESS"
+	ZoneFileEnvKey         = "ISUCON13_DNS_ZONE_FILE"
+	PublicIPEnvKey         = "ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS"
+	PublicIPv6EnvKey       = "ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS_V6"
+	QueryRateEnvKey        = "ISUCON13_DNS_QUERY_RATE"
+	QueryBurstEnvKey       = "ISUCON13_DNS_QUERY_BURST"
+	RRLRateEnvKey          = "ISUCON13_DNS_RRL_RATE"
+	RRLBurstEnvKey         = "ISUCON13_DNS_RRL_BURST"
+	RRLSlipEnvKey          = "ISUCON13_DNS_RRL_SLIP"
+	QueryLogEnvKey         = "ISUCON13_DNS_QUERY_LOG"
+	QueryLogSampleEnvKey   = "ISUCON13_DNS_QUERY_LOG_SAMPLE"
+	ForwardEnvKey          = "ISUCON13_DNS_FORWARD"
+	ForwardTimeoutEnvKey   = "ISUCON13_DNS_FORWARD_TIMEOUT"
+	ForwardCacheSizeEnvKey = "ISUCON13_DNS_FORWARD_CACHE_SIZE"
+	DNSSECKeyDirEnvKey     = "ISUCON13_DNS_DNSSEC_KEY_DIR"
+	TransferAllowEnvKey    = "ISUCON13_DNS_TRANSFER_ALLOW"
+	NotifyEnvKey           = "ISUCON13_DNS_NOTIFY"
+
+	DefaultZoneFile = "../pdns/u.isucon.dev.zone"
+	DefaultRRLSlip  = 2
+)
+
+// ConfigFromEnv は環境変数から Config を作る
+// Zone と Store は含まないので呼び出し側で設定する
+// ゾーンファイルのパスは zoneFile として返す
+func ConfigFromEnv() (cfg Config, zoneFile string, err error) {
+	cfg.Addr = DefaultAddr
+	if v, ok := os.LookupEnv(AddrEnvKey); ok && v != "" {
+		cfg.Addr = v
+	}
+
+	zoneFile = DefaultZoneFile
+	if v, ok := os.LookupEnv(ZoneFileEnvKey); ok && v != "" {
+		zoneFile = v
+	}
+
+	if cfg.PublicIP, err = ipFromEnv(PublicIPEnvKey); err != nil {
+		return cfg, "", err
+	}
+	if cfg.PublicIPv6, err = ipFromEnv(PublicIPv6EnvKey); err != nil {
+		return cfg, "", err
+	}
+
+	if cfg.RateLimit.QueryRate, cfg.RateLimit.QueryBurst, err = limitFromEnv(QueryRateEnvKey, QueryBurstEnvKey); err != nil {
+		return cfg, "", err
+	}
+	if cfg.RateLimit.RRLRate, cfg.RateLimit.RRLBurst, err = limitFromEnv(RRLRateEnvKey, RRLBurstEnvKey); err != nil {
+		return cfg, "", err
+	}
+	cfg.RateLimit.RRLSlip = DefaultRRLSlip
+	if v, ok := os.LookupEnv(RRLSlipEnvKey); ok {
+		slip, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || slip < 0 {
+			return cfg, "", fmt.Errorf("failed to parse environment variable '%s' as non-negative integer: %s", RRLSlipEnvKey, v)
+		}
+		cfg.RateLimit.RRLSlip = slip
+	}
+
+	cfg.QueryLogPath = os.Getenv(QueryLogEnvKey)
+	cfg.QueryLogSample = 1
+	if v, ok := os.LookupEnv(QueryLogSampleEnvKey); ok {
+		sample, err := strconv.Atoi(v)
+		if err != nil || sample < 1 {
+			return cfg, "", fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", QueryLogSampleEnvKey, v)
+		}
+		cfg.QueryLogSample = sample
+	}
+
+	if cfg.Forward, err = forwardFromEnv(); err != nil {
+		return cfg, "", err
+	}
+	cfg.DNSSEC.KeyDir = os.Getenv(DNSSECKeyDirEnvKey)
+
+	if cfg.TransferAllow, err = networksFromEnv(TransferAllowEnvKey); err != nil {
+		return cfg, "", err
+	}
+	for _, addr := range splitList(os.Getenv(NotifyEnvKey)) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		cfg.Notify = append(cfg.Notify, addr)
+	}
+
+	return cfg, zoneFile, nil
+}
+
+// forwardFromEnv は ISUCON13_DNS_FORWARD が設定されていればフォワーダモードにする
+// ポートを省略した場合は 53 番に転送する
+func forwardFromEnv() (ForwardConfig, error) {
+	cfg := ForwardConfig{
+		Timeout:   DefaultForwardTimeout,
+		CacheSize: DefaultForwardCacheSize,
+	}
+
+	v, ok := os.LookupEnv(ForwardEnvKey)
+	if !ok || v == "" {
+		return ForwardConfig{}, nil
+	}
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		v = net.JoinHostPort(v, "53")
+	}
+	cfg.Upstream = v
+
+	if v, ok := os.LookupEnv(ForwardTimeoutEnvKey); ok {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as positive duration: %s", ForwardTimeoutEnvKey, v)
+		}
+		cfg.Timeout = timeout
+	}
+	if v, ok := os.LookupEnv(ForwardCacheSizeEnvKey); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as non-negative integer: %s", ForwardCacheSizeEnvKey, v)
+		}
+		cfg.CacheSize = size
+	}
+
+	return cfg, nil
+}
+
+// splitList はカンマ区切りの値を分割する
+func splitList(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// networksFromEnv はカンマ区切りの IP アドレスか CIDR を読み込む
+func networksFromEnv(key string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, v := range splitList(os.Getenv(key)) {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("failed to parse environment variable '%s' as IP address: %s", key, v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as CIDR: %s", key, v)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// ipFromEnv は未設定なら nil を返す
+func ipFromEnv(key string) (net.IP, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return nil, fmt.Errorf("failed to parse environment variable '%s' as IP address: %s", key, v)
+	}
+	return ip, nil
+}
+
+// limitFromEnv は 1秒あたりの上限とバースト数を読み込む
+// バースト数が未設定なら上限と同じにする
+func limitFromEnv(rateEnvKey, burstEnvKey string) (float64, int, error) {
+	v, ok := os.LookupEnv(rateEnvKey)
+	if !ok {
+		return 0, 0, nil
+	}
+	r, err := strconv.ParseFloat(v, 64)
+	if err != nil || r < 0 {
+		return 0, 0, fmt.Errorf("failed to parse environment variable '%s' as non-negative number: %s", rateEnvKey, v)
+	}
+
+	burst := max(int(r), 1)
+	if v, ok := os.LookupEnv(burstEnvKey); ok {
+		burst, err = strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			return 0, 0, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", burstEnvKey, v)
+		}
+	}
+
+	return r, burst, nil
+}
@@ -0,0 +1,296 @@
+package dns
+
+import (
+	"crypto"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSEC のオンライン署名
+// 応答を返すたびに RRset へ RRSIG を付け、存在しない名前・型は NSEC3 で否定する
+// サブドメインはユーザ登録で増え続けるのでゾーン全体を事前署名せず、
+// NSEC3 は問い合わせ名のハッシュ値の前後だけを覆う最小範囲のもの (RFC 7129 の narrow な応答) を返す
+
+const (
+	// ECDSAP256SHA256 は署名が小さく、UDP の応答サイズを抑えられる
+	dnssecAlgorithm = dns.ECDSAP256SHA256
+	dnssecKeyBits   = 256
+
+	dnssecFlagZSK = dns.ZONE
+	dnssecFlagKSK = dns.ZONE | dns.SEP
+
+	// 署名の有効期間。時刻のずれを考慮して開始時刻を少し戻す
+	dnssecSignatureInception = 1 * time.Hour
+	dnssecSignatureValidity  = 7 * 24 * time.Hour
+)
+
+// NSEC3 のパラメータ (RFC 9276 の推奨に従い、反復なし・ソルトなし)
+const (
+	nsec3Iterations = 0
+	nsec3Salt       = ""
+)
+
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+type DNSSECConfig struct {
+	// KeyDir は鍵を置くディレクトリ
+	// BIND と同じ K<zone>+<alg>+<tag>.key / .private の形式で、なければ KSK と ZSK を生成して書き出す
+	KeyDir string
+}
+
+type signingKey struct {
+	dnskey *dns.DNSKEY
+	signer crypto.Signer
+}
+
+type signer struct {
+	origin string
+	ksk    signingKey
+	zsk    signingKey
+}
+
+func newSigner(origin string, cfg DNSSECConfig) (*signer, error) {
+	s := &signer{origin: origin}
+
+	keys, err := readSigningKeys(cfg.KeyDir, origin)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		switch key.dnskey.Flags {
+		case dnssecFlagKSK:
+			s.ksk = key
+		case dnssecFlagZSK:
+			s.zsk = key
+		}
+	}
+
+	if s.ksk.signer == nil {
+		if s.ksk, err = generateSigningKey(cfg.KeyDir, origin, dnssecFlagKSK); err != nil {
+			return nil, err
+		}
+	}
+	if s.zsk.signer == nil {
+		if s.zsk, err = generateSigningKey(cfg.KeyDir, origin, dnssecFlagZSK); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func keyFilePrefix(origin string, dnskey *dns.DNSKEY) string {
+	return fmt.Sprintf("K%s+%03d+%05d", origin, dnskey.Algorithm, dnskey.KeyTag())
+}
+
+func readSigningKeys(dir string, origin string) ([]signingKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "K"+origin+"+*.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []signingKey
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rr, err := dns.NewRR(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DNSKEY %s: %w", path, err)
+		}
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a DNSKEY", path)
+		}
+
+		privatePath := strings.TrimSuffix(path, ".key") + ".private"
+		fp, err := os.Open(privatePath)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := dnskey.ReadPrivateKey(fp, privatePath)
+		fp.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key %s: %w", privatePath, err)
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a signing key", privatePath)
+		}
+
+		keys = append(keys, signingKey{dnskey: dnskey, signer: signer})
+	}
+	return keys, nil
+}
+
+func generateSigningKey(dir string, origin string, flags uint16) (signingKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DefaultRecordTTL},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dnssecAlgorithm,
+	}
+	privateKey, err := dnskey.Generate(dnssecKeyBits)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return signingKey{}, err
+	}
+	prefix := filepath.Join(dir, keyFilePrefix(origin, dnskey))
+	if err := os.WriteFile(prefix+".private", []byte(dnskey.PrivateKeyString(privateKey)), 0600); err != nil {
+		return signingKey{}, err
+	}
+	if err := os.WriteFile(prefix+".key", []byte(dnskey.String()+"\n"), 0644); err != nil {
+		return signingKey{}, err
+	}
+
+	return signingKey{dnskey: dnskey, signer: privateKey.(crypto.Signer)}, nil
+}
+
+// dnskeys はゾーン頂点で返す DNSKEY
+func (s *signer) dnskeys(name string) []dns.RR {
+	var rrs []dns.RR
+	for _, key := range []signingKey{s.ksk, s.zsk} {
+		dnskey := dns.Copy(key.dnskey).(*dns.DNSKEY)
+		dnskey.Hdr.Name = name
+		rrs = append(rrs, dnskey)
+	}
+	return rrs
+}
+
+// ds は親ゾーンに登録する DS レコード
+func (s *signer) ds() *dns.DS {
+	return s.ksk.dnskey.ToDS(dns.SHA256)
+}
+
+func (s *signer) nsec3param(name string) *dns.NSEC3PARAM {
+	return &dns.NSEC3PARAM{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET, Ttl: 0},
+		Hash:       dns.SHA1,
+		Iterations: nsec3Iterations,
+		SaltLength: uint8(len(nsec3Salt) / 2),
+		Salt:       nsec3Salt,
+	}
+}
+
+// sign はセクション内の RRset ごとに RRSIG を付ける
+// DNSKEY は KSK、それ以外は ZSK で署名する
+func (s *signer) sign(rrs []dns.RR, now time.Time) ([]dns.RR, error) {
+	type rrsetKey struct {
+		name  string
+		class uint16
+		typ   uint16
+	}
+
+	var order []rrsetKey
+	rrsets := map[rrsetKey][]dns.RR{}
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeRRSIG || h.Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := rrsetKey{name: strings.ToLower(h.Name), class: h.Class, typ: h.Rrtype}
+		if _, ok := rrsets[key]; !ok {
+			order = append(order, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	signed := rrs
+	for _, key := range order {
+		rrset := rrsets[key]
+
+		signingKey := s.zsk
+		if key.typ == dns.TypeDNSKEY {
+			signingKey = s.ksk
+		}
+
+		rrsig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+			Algorithm:  signingKey.dnskey.Algorithm,
+			KeyTag:     signingKey.dnskey.KeyTag(),
+			SignerName: s.origin,
+			Inception:  uint32(now.Add(-dnssecSignatureInception).Unix()),
+			Expiration: uint32(now.Add(dnssecSignatureValidity).Unix()),
+		}
+		if err := rrsig.Sign(signingKey.signer, rrset); err != nil {
+			return rrs, err
+		}
+		signed = append(signed, rrsig)
+	}
+	return signed, nil
+}
+
+// nsec3Hash は name の NSEC3 ハッシュ値
+func nsec3Hash(name string) []byte {
+	b, _ := nsec3Encoding.DecodeString(dns.HashName(name, dns.SHA1, nsec3Iterations, nsec3Salt))
+	return b
+}
+
+// addHash は big endian のハッシュ値に delta (1 か -1) を足す
+func addHash(h []byte, delta int) []byte {
+	res := append([]byte(nil), h...)
+	for i := len(res) - 1; i >= 0; i-- {
+		if delta > 0 {
+			res[i]++
+			if res[i] != 0 {
+				break
+			}
+		} else {
+			res[i]--
+			if res[i] != 0xff {
+				break
+			}
+		}
+	}
+	return res
+}
+
+func (s *signer) nsec3(owner []byte, next []byte, types []uint16, ttl uint32) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(nsec3Encoding.EncodeToString(owner)) + "." + s.origin,
+			Rrtype: dns.TypeNSEC3,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Hash:       dns.SHA1,
+		Iterations: nsec3Iterations,
+		SaltLength: uint8(len(nsec3Salt) / 2),
+		Salt:       nsec3Salt,
+		HashLength: uint8(len(owner)),
+		NextDomain: nsec3Encoding.EncodeToString(next),
+		TypeBitMap: types,
+	}
+}
+
+// matchNSEC3 は存在する name の型の一覧を示す NSEC3 (NODATA と最近接存在名の証明に使う)
+func (s *signer) matchNSEC3(name string, types []uint16, ttl uint32) *dns.NSEC3 {
+	h := nsec3Hash(name)
+	return s.nsec3(h, addHash(h, 1), types, ttl)
+}
+
+// coverNSEC3 は name が存在しないことを示す NSEC3
+func (s *signer) coverNSEC3(name string, ttl uint32) *dns.NSEC3 {
+	h := nsec3Hash(name)
+	return s.nsec3(addHash(h, -1), addHash(h, 1), nil, ttl)
+}
+
+// nameErrorProof は NXDOMAIN の証明 (RFC 5155 7.2.2)
+// closestEncloser は存在する最も近い祖先、nextCloser はそれより1つ長い問い合わせ名の祖先
+func (s *signer) nameErrorProof(closestEncloser string, closestEncloserTypes []uint16, nextCloser string, ttl uint32) []dns.RR {
+	return []dns.RR{
+		s.matchNSEC3(closestEncloser, closestEncloserTypes, ttl),
+		s.coverNSEC3(nextCloser, ttl),
+		s.coverNSEC3("*."+closestEncloser, ttl),
+	}
+}
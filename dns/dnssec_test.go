@@ -0,0 +1,230 @@
+package dns
+
+import (
+	"crypto"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func dnssecQuery(name string, qtype uint16) *dns.Msg {
+	m := query(name, qtype)
+	m.SetEdns0(4096, true)
+	return m
+}
+
+// testDNSKEYs はサーバが返す DNSKEY の RRset を、その RRSIG を検証してから返す
+func testDNSKEYs(t *testing.T, addr string) []*dns.DNSKEY {
+	t.Helper()
+
+	r := exchange(t, "tcp", addr, dnssecQuery(testOrigin, dns.TypeDNSKEY))
+	var keys []*dns.DNSKEY
+	for _, rr := range r.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) != 2 {
+		t.Fatalf("DNSKEY answer = %v, want KSK and ZSK", r.Answer)
+	}
+	verifyRRSIGs(t, r.Answer, keys)
+	return keys
+}
+
+// verifyRRSIGs はセクション内のすべての RRset に keys で検証できる有効な RRSIG が付いていることを確かめる
+func verifyRRSIGs(t *testing.T, rrs []dns.RR, keys []*dns.DNSKEY) {
+	t.Helper()
+
+	type rrsetKey struct {
+		name string
+		typ  uint16
+	}
+	rrsets := map[rrsetKey][]dns.RR{}
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+			continue
+		}
+		key := rrsetKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	if len(rrsets) == 0 {
+		t.Fatal("no RRset to verify")
+	}
+
+	for key, rrset := range rrsets {
+		verified := false
+		for _, sig := range sigs {
+			if sig.TypeCovered != key.typ || !strings.EqualFold(sig.Hdr.Name, key.name) {
+				continue
+			}
+			if !sig.ValidityPeriod(time.Now()) {
+				t.Errorf("RRSIG for %s/%s is not valid now", key.name, dns.TypeToString[key.typ])
+			}
+			for _, k := range keys {
+				if k.KeyTag() != sig.KeyTag {
+					continue
+				}
+				if err := sig.Verify(k, rrset); err != nil {
+					t.Errorf("RRSIG for %s/%s: %v", key.name, dns.TypeToString[key.typ], err)
+				}
+				verified = true
+			}
+		}
+		if !verified {
+			t.Errorf("no RRSIG for %s/%s", key.name, dns.TypeToString[key.typ])
+		}
+	}
+}
+
+func nsec3s(rrs []dns.RR) []*dns.NSEC3 {
+	var res []*dns.NSEC3
+	for _, rr := range rrs {
+		if nsec3, ok := rr.(*dns.NSEC3); ok {
+			res = append(res, nsec3)
+		}
+	}
+	return res
+}
+
+func TestDNSSECSignsAnswers(t *testing.T) {
+	_, addr := startTestServer(t, Config{DNSSEC: DNSSECConfig{KeyDir: t.TempDir()}})
+	keys := testDNSKEYs(t, addr)
+
+	// DNSKEY は KSK で署名する
+	r := exchange(t, "tcp", addr, dnssecQuery(testOrigin, dns.TypeDNSKEY))
+	for _, rr := range r.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			for _, k := range keys {
+				if k.KeyTag() == sig.KeyTag && k.Flags != dns.ZONE|dns.SEP {
+					t.Errorf("DNSKEY is signed by key %d with flags %d, want the KSK", sig.KeyTag, k.Flags)
+				}
+			}
+		}
+	}
+
+	for _, q := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{"alice.u.example.", dns.TypeA},
+		{"alice.u.example.", dns.TypeAAAA},
+		{"www.u.example.", dns.TypeA},
+		{testOrigin, dns.TypeSOA},
+		{testOrigin, dns.TypeNS},
+	} {
+		t.Run(q.name+" "+dns.TypeToString[q.qtype], func(t *testing.T) {
+			r := exchange(t, "udp", addr, dnssecQuery(q.name, q.qtype))
+			if r.Rcode != dns.RcodeSuccess || len(r.Answer) < 2 {
+				t.Fatalf("rcode = %s, answer = %v", dns.RcodeToString[r.Rcode], r.Answer)
+			}
+			verifyRRSIGs(t, r.Answer, keys)
+		})
+	}
+
+	// DO ビットがなければ署名しない
+	r = exchange(t, "udp", addr, query("alice.u.example.", dns.TypeA))
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			t.Errorf("answer without DO bit has %s", rr)
+		}
+	}
+}
+
+func TestDNSSECNameErrorProof(t *testing.T) {
+	_, addr := startTestServer(t, Config{DNSSEC: DNSSECConfig{KeyDir: t.TempDir()}})
+	keys := testDNSKEYs(t, addr)
+
+	for _, tt := range []struct {
+		qname           string
+		closestEncloser string
+		nextCloser      string
+	}{
+		{"nobody.u.example.", testOrigin, "nobody.u.example."},
+		// 存在する alice の下の名前は alice が最近接存在名になる
+		{"x.y.alice.u.example.", "alice.u.example.", "y.alice.u.example."},
+	} {
+		t.Run(tt.qname, func(t *testing.T) {
+			r := exchange(t, "udp", addr, dnssecQuery(tt.qname, dns.TypeA))
+			if r.Rcode != dns.RcodeNameError {
+				t.Fatalf("rcode = %s, want NXDOMAIN", dns.RcodeToString[r.Rcode])
+			}
+			verifyRRSIGs(t, r.Ns, keys)
+
+			proof := nsec3s(r.Ns)
+			if len(proof) != 3 {
+				t.Fatalf("got %d NSEC3, want 3", len(proof))
+			}
+			// RFC 5155 7.2.2: 最近接存在名が一致し、次に近い名前とワイルドカードが覆われる
+			matched, nextCovered, wildcardCovered := false, false, false
+			for _, nsec3 := range proof {
+				matched = matched || nsec3.Match(tt.closestEncloser)
+				nextCovered = nextCovered || nsec3.Cover(tt.nextCloser)
+				wildcardCovered = wildcardCovered || nsec3.Cover("*."+tt.closestEncloser)
+				if nsec3.Match(tt.qname) || nsec3.Match(tt.nextCloser) {
+					t.Errorf("%s matches a name that should not exist", nsec3)
+				}
+			}
+			if !matched || !nextCovered || !wildcardCovered {
+				t.Errorf("closest encloser matched = %v, next closer covered = %v, wildcard covered = %v", matched, nextCovered, wildcardCovered)
+			}
+		})
+	}
+}
+
+func TestDNSSECNoDataProof(t *testing.T) {
+	_, addr := startTestServer(t, Config{DNSSEC: DNSSECConfig{KeyDir: t.TempDir()}})
+	keys := testDNSKEYs(t, addr)
+
+	r := exchange(t, "udp", addr, dnssecQuery("www.u.example.", dns.TypeAAAA))
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 {
+		t.Fatalf("rcode = %s, answer = %v", dns.RcodeToString[r.Rcode], r.Answer)
+	}
+	verifyRRSIGs(t, r.Ns, keys)
+
+	// RFC 5155 7.2.3: 問い合わせ名に一致し、問い合わせた型を含まない NSEC3
+	proof := nsec3s(r.Ns)
+	if len(proof) != 1 || !proof[0].Match("www.u.example.") {
+		t.Fatalf("NSEC3 = %v, want one matching www.u.example.", proof)
+	}
+	types := proof[0].TypeBitMap
+	if slices.Contains(types, dns.TypeAAAA) || !slices.Contains(types, dns.TypeA) || !slices.Contains(types, dns.TypeRRSIG) {
+		t.Errorf("type bitmap = %v, want A and RRSIG without AAAA", types)
+	}
+}
+
+type failingSigner struct {
+	crypto.Signer
+}
+
+func (failingSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("signing key is unavailable")
+}
+
+func TestDNSSECSigningFailureIsServFail(t *testing.T) {
+	s := newTestServer(t, Config{DNSSEC: DNSSECConfig{KeyDir: t.TempDir()}})
+	s.signer.zsk.signer = failingSigner{s.signer.zsk.signer}
+	addr := serveTestServer(t, s)
+
+	for _, name := range []string{"alice.u.example.", "nobody.u.example."} {
+		r := exchange(t, "udp", addr, dnssecQuery(name, dns.TypeA))
+		if r.Rcode != dns.RcodeServerFailure {
+			t.Errorf("%s: rcode = %s, want SERVFAIL", name, dns.RcodeToString[r.Rcode])
+		}
+		if len(r.Answer) != 0 || len(r.Ns) != 0 {
+			t.Errorf("%s: SERVFAIL has answer = %v, authority = %v", name, r.Answer, r.Ns)
+		}
+	}
+
+	// 署名しない問い合わせには答えられる
+	r := exchange(t, "udp", addr, query("alice.u.example.", dns.TypeA))
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Errorf("rcode = %s, answer = %v without DO bit", dns.RcodeToString[r.Rcode], r.Answer)
+	}
+}
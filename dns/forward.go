@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// フォワーダモード
+// 問い合わせを自分で解決せずにプライマリへ転送し、応答を TTL の間だけキャッシュする
+// UDP で受けた問い合わせは UDP で転送し、TC ビットが立っていれば TCP で問い合わせ直す
+
+const (
+	DefaultForwardTimeout   = 2 * time.Second
+	DefaultForwardCacheSize = 4096
+
+	// 応答に TTL を持つレコードがない場合 (SOA のない NXDOMAIN など) のキャッシュ時間
+	forwardNegativeTTL = 5 * time.Second
+)
+
+type ForwardConfig struct {
+	// Upstream は転送先のアドレス (host:port)
+	Upstream string
+	Timeout  time.Duration
+	// CacheSize はキャッシュする応答の最大数 (0 ならキャッシュしない)
+	CacheSize int
+}
+
+type ForwardStats struct {
+	CacheHits      int64 `json:"cache_hits"`
+	CacheMisses    int64 `json:"cache_misses"`
+	UpstreamErrors int64 `json:"upstream_errors"`
+}
+
+type forwarder struct {
+	upstream string
+	udp      *dns.Client
+	tcp      *dns.Client
+	cache    *answerCache
+
+	cacheHits      atomic.Int64
+	cacheMisses    atomic.Int64
+	upstreamErrors atomic.Int64
+}
+
+func newForwarder(cfg ForwardConfig) *forwarder {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultForwardTimeout
+	}
+
+	f := &forwarder{
+		upstream: cfg.Upstream,
+		udp:      &dns.Client{Net: "udp", Timeout: cfg.Timeout, UDPSize: dns.DefaultMsgSize},
+		tcp:      &dns.Client{Net: "tcp", Timeout: cfg.Timeout},
+	}
+	if cfg.CacheSize > 0 {
+		f.cache = newAnswerCache(cfg.CacheSize)
+	}
+	return f
+}
+
+func (f *forwarder) stats() ForwardStats {
+	return ForwardStats{
+		CacheHits:      f.cacheHits.Load(),
+		CacheMisses:    f.cacheMisses.Load(),
+		UpstreamErrors: f.upstreamErrors.Load(),
+	}
+}
+
+// forward は r をプライマリに転送した応答を返す
+// 転送に失敗した場合は m を SERVFAIL にして返す
+func (f *forwarder) forward(r *dns.Msg, m *dns.Msg, isUDP bool, now time.Time) *dns.Msg {
+	key, cacheable := cacheKey(r)
+	if cacheable && f.cache != nil {
+		if cached, ok := f.cache.get(key, now); ok {
+			f.cacheHits.Add(1)
+			return answerFor(r, cached)
+		}
+		f.cacheMisses.Add(1)
+	}
+
+	res, err := f.exchange(r, isUDP)
+	if err != nil {
+		f.upstreamErrors.Add(1)
+		m.Rcode = dns.RcodeServerFailure
+		return m
+	}
+	res.Id = r.Id
+
+	if cacheable && f.cache != nil && !res.Truncated &&
+		(res.Rcode == dns.RcodeSuccess || res.Rcode == dns.RcodeNameError) {
+		f.cache.put(key, res, now)
+	}
+	return res
+}
+
+// answerFor はキャッシュから取り出した応答 (呼び出し元だけが持つコピー) を r への応答に書き換える
+// キャッシュのキーは小文字なので、0x20 (問い合わせ名の大文字小文字を混ぜる) を使うリゾルバに
+// 合わせて、質問と問い合わせ名のレコードの所有者名を r の表記に戻す
+func answerFor(r *dns.Msg, m *dns.Msg) *dns.Msg {
+	m.Id = r.Id
+	m.Question = append([]dns.Question(nil), r.Question...)
+
+	qname := r.Question[0].Name
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if strings.EqualFold(rr.Header().Name, qname) {
+				rr.Header().Name = qname
+			}
+		}
+	}
+	return m
+}
+
+func (f *forwarder) exchange(r *dns.Msg, isUDP bool) (*dns.Msg, error) {
+	req := r.Copy()
+	req.Id = dns.Id()
+
+	if isUDP {
+		res, _, err := f.udp.Exchange(req, f.upstream)
+		if err != nil || !res.Truncated {
+			return res, err
+		}
+	}
+	res, _, err := f.tcp.Exchange(req, f.upstream)
+	return res, err
+}
+
+// cacheKey は問い合わせ名・種別・クラスと DO ビットからキャッシュのキーを作る
+// 質問が1つでない問い合わせはキャッシュしない
+func cacheKey(r *dns.Msg) (string, bool) {
+	if len(r.Question) != 1 {
+		return "", false
+	}
+	q := r.Question[0]
+
+	do := "0"
+	if opt := r.IsEdns0(); opt != nil && opt.Do() {
+		do = "1"
+	}
+	return strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype] + "/" + dns.ClassToString[q.Qclass] + "/" + do, true
+}
+
+type cacheEntry struct {
+	key     string
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// answerCache は応答の LRU キャッシュ
+type answerCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newAnswerCache(size int) *answerCache {
+	return &answerCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// get はキャッシュした応答のコピーを、経過時間だけ TTL を減らして返す
+func (c *answerCache) get(key string, now time.Time) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+
+	m := entry.msg.Copy()
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			rr.Header().Ttl -= min(age, rr.Header().Ttl)
+		}
+	}
+	return m, true
+}
+
+func (c *answerCache) put(key string, m *dns.Msg, now time.Time) {
+	ttl, ok := minTTL(m)
+	if !ok {
+		ttl = forwardNegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+	for c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		msg:     m.Copy(),
+		stored:  now,
+		expires: now.Add(ttl),
+	})
+}
+
+// minTTL は応答に含まれるレコードの最小の TTL を返す
+func minTTL(m *dns.Msg) (time.Duration, bool) {
+	var ttl uint32
+	found := false
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second, found
+}
@@ -0,0 +1,31 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestForwarderCachePreservesQueryCase(t *testing.T) {
+	_, upstream := startTestServer(t, Config{})
+	fwd, addr := startTestServer(t, Config{Forward: ForwardConfig{Upstream: upstream, CacheSize: 16}})
+
+	// 1回目でキャッシュし、2回目以降は表記の違う名前でキャッシュから返す
+	for _, name := range []string{"www.u.example.", "WwW.U.eXaMpLe.", "wWw.u.EXAMPLE."} {
+		r := exchange(t, "udp", addr, query(name, dns.TypeA))
+		if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+			t.Fatalf("%s: rcode = %s, answer = %v", name, dns.RcodeToString[r.Rcode], r.Answer)
+		}
+		if len(r.Question) != 1 || r.Question[0].Name != name {
+			t.Errorf("question = %v, want %s", r.Question, name)
+		}
+		if got := r.Answer[0].Header().Name; got != name {
+			t.Errorf("answer owner = %s, want %s", got, name)
+		}
+	}
+
+	stats := fwd.Metrics().Forward
+	if stats == nil || stats.CacheHits != 2 || stats.CacheMisses != 1 {
+		t.Errorf("forward stats = %+v, want 2 hits and 1 miss", stats)
+	}
+}
@@ -0,0 +1,415 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalStore はレコードをファイルに永続化する RecordStore
+// 変更のたびにジャーナルへ追記して fsync し、定期的にスナップショットへまとめる
+// 開くときはスナップショットを読み込んだあとジャーナルを再生する
+
+const (
+	snapshotFile    = "records.txt"
+	snapshotTmpFile = "records-tmp.txt"
+	journalFile     = "records.journal"
+)
+
+const (
+	journalOpAdd    = "add"
+	journalOpDelete = "delete"
+	// journalOpSerial はスナップショットにまとめたときのシリアル
+	journalOpSerial = "serial"
+)
+
+// journalEntry の Serial は変更後のシリアル (以前のジャーナルにはない)
+type journalEntry struct {
+	Op      string `json:"op"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	Serial  uint32 `json:"serial,omitempty"`
+}
+
+// snapshot はスナップショットファイルの中身
+// 以前のスナップショットはレコードの配列だけなので、配列も受け付ける
+type snapshot struct {
+	Serial  uint32          `json:"serial"`
+	Records []snapshotEntry `json:"records"`
+	// Zone はレコードに取り込み済みのゾーンファイルの名前
+	// ここにない名前だけを取り込むので、削除したゾーンファイルの名前は戻らない
+	Zone []string `json:"zone,omitempty"`
+}
+
+func (sn *snapshot) UnmarshalJSON(b []byte) error {
+	var records []snapshotEntry
+	if err := json.Unmarshal(b, &records); err == nil {
+		*sn = snapshot{Records: records}
+		return nil
+	}
+
+	type plain snapshot
+	return json.Unmarshal(b, (*plain)(sn))
+}
+
+// snapshotEntry はスナップショットの1レコード
+// 以前のスナップショットはサブドメイン名だけの配列なので、文字列も受け付ける
+type snapshotEntry struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+func (e *snapshotEntry) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*e = snapshotEntry{Name: name}
+		return nil
+	}
+
+	type entry snapshotEntry
+	return json.Unmarshal(b, (*entry)(e))
+}
+
+type JournalStore struct {
+	*MemoryStore
+
+	dir string
+	// zone はゾーンファイルの名前 (スナップショットに取り込み済みとして書く)
+	zone []string
+
+	// mu はジャーナルへの書き込みと MemoryStore への反映の順序を揃える
+	mu      sync.Mutex
+	journal *os.File
+	entries int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ RecordStore = (*JournalStore)(nil)
+
+// OpenJournalStore は dir のスナップショットとジャーナルからレコードを復元する
+// defaults (ゾーンファイルのサブドメイン) のうち、スナップショットに取り込んでいない名前を足すので、
+// 後からゾーンファイルに足した名前も引ける (API で削除した名前は戻さない)
+// compactInterval ごとにスナップショットへまとめる (0 なら定期的にはまとめない)
+func OpenJournalStore(dir string, defaults map[string]Record, compactInterval time.Duration) (*JournalStore, error) {
+	s := &JournalStore{
+		MemoryStore: NewMemoryStore(nil),
+		dir:         dir,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for name := range defaults {
+		s.zone = append(s.zone, name)
+	}
+	sort.Strings(s.zone)
+
+	sn, err := s.readSnapshot()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read DNS snapshot: %w", err)
+	}
+	records := map[string]Record{}
+	for _, entry := range sn.Records {
+		records[entry.Name] = Record{Address: entry.Address}
+	}
+	merged := map[string]bool{}
+	for _, name := range sn.Zone {
+		merged[name] = true
+	}
+	// Zone のない以前のスナップショットでは、足りない名前をすべて足す
+	added := false
+	for name, record := range defaults {
+		if _, ok := records[name]; !ok && !merged[name] {
+			records[name] = record
+			added = true
+		}
+	}
+	s.MemoryStore.reset(records)
+	if sn.Serial != 0 {
+		s.MemoryStore.setSerial(sn.Serial)
+	}
+
+	n, err := s.replay()
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay DNS journal: %w", err)
+	}
+	if added {
+		// ゾーンファイルから名前が増えたので、セカンダリが転送し直すようにシリアルを進める
+		s.MemoryStore.setSerial(s.MemoryStore.Serial() + 1)
+	}
+
+	fp, err := os.OpenFile(s.path(journalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DNS journal: %w", err)
+	}
+	s.journal = fp
+	s.entries = n
+
+	go s.compactPeriodically(compactInterval)
+
+	return s, nil
+}
+
+func (s *JournalStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// readSnapshot はスナップショットを読む
+// シリアルのない以前のスナップショットなら Serial は 0
+func (s *JournalStore) readSnapshot() (snapshot, error) {
+	var sn snapshot
+
+	fp, err := os.Open(s.path(snapshotFile))
+	if err != nil {
+		return sn, err
+	}
+	defer fp.Close()
+
+	if err := json.NewDecoder(fp).Decode(&sn); err != nil && err != io.EOF {
+		return snapshot{}, err
+	}
+	return sn, nil
+}
+
+// replay はジャーナルの内容を反映し、再生したエントリ数を返す
+// 書き込み途中でクラッシュした末尾の行は読み捨て、次の追記がその後ろに続かないようファイルから切り詰める
+func (s *JournalStore) replay() (int, error) {
+	fp, err := os.Open(s.path(journalFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+
+	n := 0
+	// complete は最後の完全な行の終わりの位置
+	var complete int64
+	r := bufio.NewReader(fp)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("discard incomplete DNS journal entry: %q\n", line)
+				if err := os.Truncate(s.path(journalFile), complete); err != nil {
+					return n, err
+				}
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		complete += int64(len(line))
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return n, fmt.Errorf("entry %d: %w", n+1, err)
+		}
+
+		switch entry.Op {
+		case journalOpAdd:
+			s.MemoryStore.Put(entry.Name, Record{Address: entry.Address})
+		case journalOpDelete:
+			s.MemoryStore.Delete(entry.Name)
+		case journalOpSerial:
+		default:
+			return n, fmt.Errorf("entry %d: unknown op '%s'", n+1, entry.Op)
+		}
+		if entry.Serial != 0 {
+			s.MemoryStore.setSerial(entry.Serial)
+		}
+		if entry.Op != journalOpSerial {
+			n++
+		}
+	}
+}
+
+func (s *JournalStore) append(entry journalEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if _, err := s.journal.Write(b); err != nil {
+		return err
+	}
+	if err := s.journal.Sync(); err != nil {
+		return err
+	}
+	s.entries++
+	return nil
+}
+
+// Put はレコードを永続化してから名前解決できるようにする
+// 既に登録されている場合は上書きする
+func (s *JournalStore) Put(name string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := journalEntry{Op: journalOpAdd, Name: name, Address: record.Address, Serial: s.MemoryStore.Serial() + 1}
+	if err := s.append(entry); err != nil {
+		return err
+	}
+	return s.MemoryStore.Put(name, record)
+}
+
+// Add は登録されていない場合だけレコードを永続化して追加する
+// 既に登録されている場合は上書きせずに false を返す
+func (s *JournalStore) Add(name string, record Record) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.MemoryStore.Lookup(name); found {
+		return false, nil
+	}
+
+	entry := journalEntry{Op: journalOpAdd, Name: name, Address: record.Address, Serial: s.MemoryStore.Serial() + 1}
+	if err := s.append(entry); err != nil {
+		return false, err
+	}
+	return s.MemoryStore.Add(name, record)
+}
+
+// Delete はレコードを削除する
+// 登録されていなかった場合は false を返す
+func (s *JournalStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.MemoryStore.Lookup(name); !found {
+		return false, nil
+	}
+
+	entry := journalEntry{Op: journalOpDelete, Name: name, Serial: s.MemoryStore.Serial() + 1}
+	if err := s.append(entry); err != nil {
+		return false, err
+	}
+	return s.MemoryStore.Delete(name)
+}
+
+// Reset はレコードを置き換えて、すぐにスナップショットへ書き出す
+func (s *JournalStore) Reset(records map[string]Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.MemoryStore.reset(records)
+	return s.compact()
+}
+
+// Compact は現在のレコードをスナップショットに書き出し、ジャーナルを空にする
+func (s *JournalStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+func (s *JournalStore) compact() error {
+	if err := s.writeSnapshot(s.MemoryStore.List(), s.MemoryStore.Serial(), s.zone); err != nil {
+		return err
+	}
+
+	// スナップショットに含まれたのでジャーナルは不要
+	// ここでクラッシュしても再生は冪等なので問題ない
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	// シリアルが戻らないよう、空にしたジャーナルの先頭に残す
+	if err := s.append(journalEntry{Op: journalOpSerial, Serial: s.MemoryStore.Serial()}); err != nil {
+		return err
+	}
+	s.entries = 0
+
+	return nil
+}
+
+func (s *JournalStore) writeSnapshot(records map[string]Record, serial uint32, zone []string) error {
+	entries := make([]snapshotEntry, 0, len(records))
+	for name, record := range records {
+		entries = append(entries, snapshotEntry{Name: name, Address: record.Address})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	fp, err := os.Create(s.path(snapshotTmpFile))
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(fp).Encode(&snapshot{Serial: serial, Records: entries, Zone: zone}); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(s.path(snapshotTmpFile), s.path(snapshotFile)); err != nil {
+		return err
+	}
+
+	// rename を永続化する
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (s *JournalStore) compactPeriodically(interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if s.entries > 0 {
+			if err := s.compact(); err != nil {
+				log.Println(err)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close はスナップショットを書き出してジャーナルを閉じる
+func (s *JournalStore) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.compact(); err != nil {
+		s.journal.Close()
+		return err
+	}
+	return s.journal.Close()
+}
@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testJournalDefaults = map[string]Record{"www": {}, "mail": {}}
+
+func openTestJournal(t *testing.T, dir string, defaults map[string]Record) *JournalStore {
+	t.Helper()
+
+	s, err := OpenJournalStore(dir, defaults, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// crash はスナップショットにまとめずにジャーナルを閉じる
+func crash(t *testing.T, s *JournalStore) {
+	t.Helper()
+
+	close(s.stop)
+	<-s.done
+	if err := s.journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertRecords(t *testing.T, s *JournalStore, want map[string]Record) {
+	t.Helper()
+
+	if got := s.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJournal(t, dir, testJournalDefaults)
+	s.Put("alice", Record{})
+	s.Put("bob", Record{Address: "192.0.2.10"})
+	s.Delete("alice")
+	s.Delete("mail")
+	serial := s.Serial()
+	crash(t, s)
+
+	s = openTestJournal(t, dir, testJournalDefaults)
+	defer s.Close()
+	assertRecords(t, s, map[string]Record{"www": {}, "bob": {Address: "192.0.2.10"}})
+	if s.Serial() < serial {
+		t.Errorf("serial = %d after replay, want at least %d", s.Serial(), serial)
+	}
+}
+
+func TestJournalTornLastLine(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJournal(t, dir, testJournalDefaults)
+	s.Put("alice", Record{})
+	crash(t, s)
+
+	// 書き込み途中でクラッシュした行
+	fp, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.WriteString(`{"op":"add","na`)
+	fp.Close()
+
+	s = openTestJournal(t, dir, testJournalDefaults)
+	s.Put("bob", Record{})
+	crash(t, s)
+
+	// 切り詰めていなければ、bob の行が壊れた行の後ろに続いて開けなくなる
+	s = openTestJournal(t, dir, testJournalDefaults)
+	defer s.Close()
+	assertRecords(t, s, map[string]Record{"www": {}, "mail": {}, "alice": {}, "bob": {}})
+}
+
+func TestJournalSerialAcrossCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJournal(t, dir, testJournalDefaults)
+	s.Put("alice", Record{})
+	before := s.Serial()
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Serial() != before {
+		t.Errorf("serial = %d after compaction, want %d", s.Serial(), before)
+	}
+	s.Put("bob", Record{})
+	if s.Serial() <= before {
+		t.Errorf("serial = %d after put, want more than %d", s.Serial(), before)
+	}
+	serial := s.Serial()
+
+	// ジャーナルを空にした直後にクラッシュしても戻らない
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, s)
+	s = openTestJournal(t, dir, testJournalDefaults)
+	if s.Serial() != serial {
+		t.Errorf("serial = %d after reopen, want %d", s.Serial(), serial)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestJournal(t, dir, testJournalDefaults)
+	defer s.Close()
+	if s.Serial() != serial {
+		t.Errorf("serial = %d after close and reopen, want %d", s.Serial(), serial)
+	}
+}
+
+func TestJournalLegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte(`["alice","bob"]`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestJournal(t, dir, testJournalDefaults)
+	defer s.Close()
+	assertRecords(t, s, map[string]Record{"www": {}, "mail": {}, "alice": {}, "bob": {}})
+}
+
+func TestJournalZoneLabelDeleteSurvivesCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJournal(t, dir, testJournalDefaults)
+	s.Delete("mail")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestJournal(t, dir, testJournalDefaults)
+	assertRecords(t, s, map[string]Record{"www": {}})
+	serial := s.Serial()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ゾーンファイルに後から足した名前だけが増える
+	defaults := map[string]Record{"www": {}, "mail": {}, "ns2": {}}
+	s = openTestJournal(t, dir, defaults)
+	defer s.Close()
+	assertRecords(t, s, map[string]Record{"www": {}, "ns2": {}})
+	if s.Serial() <= serial {
+		t.Errorf("serial = %d after adding a zone name, want more than %d", s.Serial(), serial)
+	}
+}
+
+func TestJournalAddKeepsExistingRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestJournal(t, dir, testJournalDefaults)
+	s.Put("alice", Record{Address: "192.0.2.10"})
+	serial := s.Serial()
+
+	if added, err := s.Add("alice", Record{}); err != nil || added {
+		t.Errorf("Add(alice) = %v, %v, want false", added, err)
+	}
+	if s.Serial() != serial {
+		t.Errorf("serial = %d after a no-op add, want %d", s.Serial(), serial)
+	}
+	if added, err := s.Add("bob", Record{}); err != nil || !added {
+		t.Errorf("Add(bob) = %v, %v, want true", added, err)
+	}
+	crash(t, s)
+
+	s = openTestJournal(t, dir, testJournalDefaults)
+	defer s.Close()
+	assertRecords(t, s, map[string]Record{"www": {}, "mail": {}, "alice": {Address: "192.0.2.10"}, "bob": {}})
+}
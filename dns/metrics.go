@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSサーバのメトリクス
+// 問い合わせ種別・応答コードごとの件数、レコードストアのヒット率、応答時間のヒストグラムを集計する
+// クエリログを設定すると、問い合わせをサンプリングしてファイルに書き出す
+
+const (
+	queryLogBufferSize = 4096
+	queryLogFlushEvery = 1 * time.Second
+)
+
+// 応答時間ヒストグラムのバケット上限 (マイクロ秒)
+var latencyBuckets = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000}
+
+type LatencyBucket struct {
+	// LE はバケットの上限 (マイクロ秒)。最後のバケットは上限なしで -1
+	LE    int64 `json:"le"`
+	Count int64 `json:"count"`
+}
+
+type Metrics struct {
+	Queries          map[string]int64 `json:"queries"`
+	Responses        map[string]int64 `json:"responses"`
+	LookupHits       int64            `json:"lookup_hits"`
+	LookupMisses     int64            `json:"lookup_misses"`
+	LatencyBuckets   []LatencyBucket  `json:"latency_buckets"`
+	LatencySumMicros int64            `json:"latency_sum_us"`
+	LatencyCount     int64            `json:"latency_count"`
+	RateLimit        RateLimitStats   `json:"rate_limit"`
+	// Forward はフォワーダモードの場合だけ
+	Forward *ForwardStats `json:"forward,omitempty"`
+}
+
+type counters struct {
+	m sync.Map // map[string]*atomic.Int64
+}
+
+func (c *counters) increment(key string) {
+	v, ok := c.m.Load(key)
+	if !ok {
+		v, _ = c.m.LoadOrStore(key, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Add(1)
+}
+
+func (c *counters) snapshot() map[string]int64 {
+	res := map[string]int64{}
+	c.m.Range(func(key, v any) bool {
+		res[key.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return res
+}
+
+type metrics struct {
+	queriesByType   counters
+	responsesByCode counters
+	lookupHits      atomic.Int64
+	lookupMisses    atomic.Int64
+	latencyCounts   []atomic.Int64
+	latencySum      atomic.Int64
+	latencyCount    atomic.Int64
+
+	queryLog       chan string
+	queryLogSample int
+}
+
+func newMetrics() *metrics {
+	return &metrics{latencyCounts: make([]atomic.Int64, len(latencyBuckets)+1)}
+}
+
+func (mt *metrics) recordLookup(found bool) {
+	if found {
+		mt.lookupHits.Add(1)
+	} else {
+		mt.lookupMisses.Add(1)
+	}
+}
+
+// record は1件の問い合わせの集計をする
+// m はレート制限で応答を捨てた場合 nil
+func (mt *metrics) record(addr net.Addr, r *dns.Msg, m *dns.Msg, elapsed time.Duration) {
+	qname, qtype := "", "NONE"
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+		qtype = dns.TypeToString[r.Question[0].Qtype]
+		if qtype == "" {
+			qtype = strconv.Itoa(int(r.Question[0].Qtype))
+		}
+	}
+	mt.queriesByType.increment(qtype)
+
+	rcode := "DROPPED"
+	if m != nil {
+		rcode = dns.RcodeToString[m.Rcode]
+		if m.Truncated {
+			rcode += "+TC"
+		}
+	}
+	mt.responsesByCode.increment(rcode)
+
+	us := elapsed.Microseconds()
+	i := 0
+	for i < len(latencyBuckets) && us > latencyBuckets[i] {
+		i++
+	}
+	mt.latencyCounts[i].Add(1)
+	mt.latencySum.Add(us)
+	mt.latencyCount.Add(1)
+
+	mt.logQuery(addr, qname, qtype, rcode, elapsed)
+}
+
+func (mt *metrics) snapshot() Metrics {
+	buckets := make([]LatencyBucket, len(mt.latencyCounts))
+	for i := range mt.latencyCounts {
+		le := int64(-1)
+		if i < len(latencyBuckets) {
+			le = latencyBuckets[i]
+		}
+		buckets[i] = LatencyBucket{LE: le, Count: mt.latencyCounts[i].Load()}
+	}
+
+	return Metrics{
+		Queries:          mt.queriesByType.snapshot(),
+		Responses:        mt.responsesByCode.snapshot(),
+		LookupHits:       mt.lookupHits.Load(),
+		LookupMisses:     mt.lookupMisses.Load(),
+		LatencyBuckets:   buckets,
+		LatencySumMicros: mt.latencySum.Load(),
+		LatencyCount:     mt.latencyCount.Load(),
+	}
+}
+
+// openQueryLog はクエリログの書き出しを始める
+// sample 件に1件を書き出す
+// 書き込みが詰まっても応答を遅らせないよう、バッファが溢れた分は捨てる
+func (mt *metrics) openQueryLog(path string, sample int, stop <-chan struct{}) error {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	mt.queryLogSample = max(sample, 1)
+	mt.queryLog = make(chan string, queryLogBufferSize)
+	go func() {
+		defer fp.Close()
+
+		w := bufio.NewWriter(fp)
+		defer w.Flush()
+
+		ticker := time.NewTicker(queryLogFlushEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case line := <-mt.queryLog:
+				w.WriteString(line)
+			case <-ticker.C:
+				if err := w.Flush(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (mt *metrics) logQuery(addr net.Addr, qname, qtype, rcode string, elapsed time.Duration) {
+	if mt.queryLog == nil {
+		return
+	}
+	if mt.queryLogSample > 1 && rand.Intn(mt.queryLogSample) != 0 {
+		return
+	}
+
+	line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%d\n", time.Now().Format(time.RFC3339Nano), addr, qname, qtype, rcode, elapsed.Microseconds())
+	select {
+	case mt.queryLog <- line:
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMetricsCounters(t *testing.T) {
+	mt := newMetrics()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	response := func(r *dns.Msg, rcode int, truncated bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		m.Truncated = truncated
+		return m
+	}
+
+	a, aaaa := query("alice.u.example.", dns.TypeA), query("nobody.u.example.", dns.TypeAAAA)
+	mt.record(addr, a, response(a, dns.RcodeSuccess, false), 30*time.Microsecond)
+	mt.record(addr, a, response(a, dns.RcodeSuccess, true), 300*time.Microsecond)
+	mt.record(addr, aaaa, response(aaaa, dns.RcodeNameError, false), 300*time.Microsecond)
+	mt.record(addr, a, nil, time.Second)
+	mt.recordLookup(true)
+	mt.recordLookup(false)
+	mt.recordLookup(false)
+
+	got := mt.snapshot()
+	if want := map[string]int64{"A": 3, "AAAA": 1}; !reflect.DeepEqual(got.Queries, want) {
+		t.Errorf("queries = %v, want %v", got.Queries, want)
+	}
+	if want := map[string]int64{"NOERROR": 1, "NOERROR+TC": 1, "NXDOMAIN": 1, "DROPPED": 1}; !reflect.DeepEqual(got.Responses, want) {
+		t.Errorf("responses = %v, want %v", got.Responses, want)
+	}
+	if got.LookupHits != 1 || got.LookupMisses != 2 {
+		t.Errorf("lookup hits = %d, misses = %d, want 1, 2", got.LookupHits, got.LookupMisses)
+	}
+
+	// 30us は最初のバケット、300us は 500us のバケット、1s は上限なしのバケット
+	counts := map[int64]int64{}
+	for _, b := range got.LatencyBuckets {
+		counts[b.LE] = b.Count
+	}
+	if counts[50] != 1 || counts[500] != 2 || counts[-1] != 1 {
+		t.Errorf("latency buckets = %v", got.LatencyBuckets)
+	}
+	if got.LatencyCount != 4 || got.LatencySumMicros != 30+300+300+1000000 {
+		t.Errorf("latency count = %d, sum = %d", got.LatencyCount, got.LatencySumMicros)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s, addr := startTestServer(t, Config{})
+
+	exchange(t, "udp", addr, query("alice.u.example.", dns.TypeA))
+	exchange(t, "tcp", addr, query("alice.u.example.", dns.TypeAAAA))
+	exchange(t, "udp", addr, query("nobody.u.example.", dns.TypeA))
+
+	// 集計は応答を書き出した後なので、揃うまで待つ
+	var got Metrics
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if got = s.Metrics(); got.LatencyCount >= 3 {
+			break
+		}
+	}
+	if got.Queries["A"] != 2 || got.Queries["AAAA"] != 1 {
+		t.Errorf("queries = %v", got.Queries)
+	}
+	if got.Responses["NOERROR"] != 2 || got.Responses["NXDOMAIN"] != 1 {
+		t.Errorf("responses = %v", got.Responses)
+	}
+	if got.LatencyCount != 3 {
+		t.Errorf("latency count = %d, want 3", got.LatencyCount)
+	}
+}
@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+)
+
+// ランダムサブドメインによる flood 対策
+// UDP の問い合わせだけを対象にする (TCP は送信元を詐称できないため)
+//   - 送信元IPごとのトークンバケットで問い合わせを捨てる
+//   - RRL (Response Rate Limiting): 送信元プレフィックス + 応答の種類ごとに応答数を制限し、
+//     超過した応答のうち slip 回に1回は TC ビットだけ立てた空応答を返して TCP での再送を促す
+
+const (
+	rrlIPv4PrefixLen = 24
+	rrlIPv6PrefixLen = 56
+
+	limiterIdleTimeout = 1 * time.Minute
+)
+
+// RateLimitConfig はレート制限の設定
+// Rate が 0 の場合はその制限を無効にする
+type RateLimitConfig struct {
+	// QueryRate は送信元IPごとの1秒あたりの問い合わせ数
+	QueryRate  float64
+	QueryBurst int
+	// RRLRate は送信元プレフィックス + 応答の種類ごとの1秒あたりの応答数
+	RRLRate  float64
+	RRLBurst int
+	// RRLSlip は制限された応答のうち何回に1回 TC ビットだけの応答を返すか (0 なら常に捨てる)
+	RRLSlip int64
+}
+
+type RateLimitStats struct {
+	QueriesDropped   int64 `json:"queries_dropped"`
+	ResponsesDropped int64 `json:"responses_dropped"`
+	ResponsesSlipped int64 `json:"responses_slipped"`
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64
+	limited  atomic.Int64
+}
+
+// limiter はキーごとのトークンバケット
+// rate が 0 の場合は無効
+type limiter struct {
+	rate    rate.Limit
+	burst   int
+	buckets sync.Map // map[string]*bucket
+}
+
+func newLimiter(r float64, burst int) *limiter {
+	return &limiter{rate: rate.Limit(r), burst: max(burst, 1)}
+}
+
+func (l *limiter) enabled() bool {
+	return l.rate > 0
+}
+
+// allow は key のバケットからトークンを取り出す
+// 取り出せなかった場合は、そのバケットで連続して制限された回数を返す
+func (l *limiter) allow(key string, now time.Time) (bool, int64) {
+	v, ok := l.buckets.Load(key)
+	if !ok {
+		v, _ = l.buckets.LoadOrStore(key, &bucket{limiter: rate.NewLimiter(l.rate, l.burst)})
+	}
+	b := v.(*bucket)
+	b.lastSeen.Store(now.UnixNano())
+
+	if b.limiter.AllowN(now, 1) {
+		b.limited.Store(0)
+		return true, 0
+	}
+	return false, b.limited.Add(1)
+}
+
+func (l *limiter) sweep(now time.Time) {
+	deadline := now.Add(-limiterIdleTimeout).UnixNano()
+	l.buckets.Range(func(key, v any) bool {
+		if v.(*bucket).lastSeen.Load() < deadline {
+			l.buckets.Delete(key)
+		}
+		return true
+	})
+}
+
+type rateLimiter struct {
+	query *limiter
+	rrl   *limiter
+	slip  int64
+
+	queriesDropped   atomic.Int64
+	responsesDropped atomic.Int64
+	responsesSlipped atomic.Int64
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		query: newLimiter(cfg.QueryRate, cfg.QueryBurst),
+		rrl:   newLimiter(cfg.RRLRate, cfg.RRLBurst),
+		slip:  cfg.RRLSlip,
+	}
+}
+
+func (rl *rateLimiter) enabled() bool {
+	return rl.query.enabled() || rl.rrl.enabled()
+}
+
+func (rl *rateLimiter) sweepPeriodically(stop <-chan struct{}) {
+	if !rl.enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(limiterIdleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			rl.query.sweep(now)
+			rl.rrl.sweep(now)
+		}
+	}
+}
+
+func (rl *rateLimiter) stats() RateLimitStats {
+	return RateLimitStats{
+		QueriesDropped:   rl.queriesDropped.Load(),
+		ResponsesDropped: rl.responsesDropped.Load(),
+		ResponsesSlipped: rl.responsesSlipped.Load(),
+	}
+}
+
+// allowQuery は送信元IPごとの問い合わせレート制限
+func (rl *rateLimiter) allowQuery(addr *net.UDPAddr, now time.Time) bool {
+	if !rl.query.enabled() {
+		return true
+	}
+	if ok, _ := rl.query.allow(addr.IP.String(), now); ok {
+		return true
+	}
+	rl.queriesDropped.Add(1)
+	return false
+}
+
+// limitResponse は RRL を適用する
+// 応答をそのまま返す場合は m を、slip する場合は TC ビットだけの応答を、捨てる場合は nil を返す
+func (rl *rateLimiter) limitResponse(addr *net.UDPAddr, m *dns.Msg, origin string, now time.Time) *dns.Msg {
+	if !rl.rrl.enabled() {
+		return m
+	}
+
+	ok, limited := rl.rrl.allow(rrlKey(addr.IP, m, origin), now)
+	if ok {
+		return m
+	}
+
+	if rl.slip > 0 && limited%rl.slip == 0 {
+		rl.responsesSlipped.Add(1)
+		slip := &dns.Msg{MsgHdr: m.MsgHdr, Question: m.Question}
+		slip.Truncated = true
+		return slip
+	}
+
+	rl.responsesDropped.Add(1)
+	return nil
+}
+
+// rrlKey は送信元プレフィックスと応答の種類からバケットのキーを作る
+// NXDOMAIN はランダムサブドメインごとにバケットが分かれないようゾーン名でまとめる
+func rrlKey(ip net.IP, m *dns.Msg, origin string) string {
+	var prefix net.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix = ip4.Mask(net.CIDRMask(rrlIPv4PrefixLen, 32))
+	} else {
+		prefix = ip.Mask(net.CIDRMask(rrlIPv6PrefixLen, 128))
+	}
+
+	var name, kind string
+	if len(m.Question) > 0 {
+		name = strings.ToLower(m.Question[0].Name)
+	}
+	switch {
+	case m.Rcode == dns.RcodeNameError:
+		kind = "nxdomain"
+		name = origin
+	case m.Rcode != dns.RcodeSuccess:
+		kind = "error"
+		name = ""
+	case len(m.Answer) == 0:
+		kind = "nodata"
+	default:
+		kind = "answer"
+	}
+
+	return prefix.String() + "/" + kind + "/" + name
+}
@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answerMsg(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.Response = true
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+		A:   net.ParseIP("192.0.2.1"),
+	}}
+	return m
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l := newLimiter(1, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("192.0.2.1", now); !ok {
+			t.Fatalf("query %d within the burst is refused", i+1)
+		}
+	}
+	for i := int64(1); i <= 2; i++ {
+		ok, limited := l.allow("192.0.2.1", now)
+		if ok || limited != i {
+			t.Errorf("allow = %v, %d after the burst, want false, %d", ok, limited, i)
+		}
+	}
+	// 他のキーのバケットは別
+	if ok, _ := l.allow("192.0.2.2", now); !ok {
+		t.Error("another key is refused")
+	}
+	// トークンが溜まれば通し、制限された回数も数え直す
+	if ok, limited := l.allow("192.0.2.1", now.Add(time.Second)); !ok || limited != 0 {
+		t.Errorf("allow = %v, %d after a second, want true, 0", ok, limited)
+	}
+}
+
+func TestRateLimiterDropsQueries(t *testing.T) {
+	rl := newRateLimiter(RateLimitConfig{QueryRate: 1, QueryBurst: 2})
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	now := time.Now()
+
+	got := []bool{rl.allowQuery(addr, now), rl.allowQuery(addr, now), rl.allowQuery(addr, now)}
+	if !got[0] || !got[1] || got[2] {
+		t.Errorf("allowQuery = %v, want [true true false]", got)
+	}
+	if n := rl.stats().QueriesDropped; n != 1 {
+		t.Errorf("queries dropped = %d, want 1", n)
+	}
+}
+
+func TestRRLSlip(t *testing.T) {
+	rl := newRateLimiter(RateLimitConfig{RRLRate: 1, RRLBurst: 1, RRLSlip: 2})
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	now := time.Now()
+	m := answerMsg("alice.u.example.")
+
+	if got := rl.limitResponse(addr, m, testOrigin, now); got != m {
+		t.Fatalf("first response = %v, want as is", got)
+	}
+	// 制限された1回目は捨て、2回目は slip する
+	if got := rl.limitResponse(addr, m, testOrigin, now); got != nil {
+		t.Fatalf("limited response = %v, want dropped", got)
+	}
+	got := rl.limitResponse(addr, m, testOrigin, now)
+	if got == nil || !got.Truncated {
+		t.Fatalf("slipped response = %v, want TC", got)
+	}
+	if got.Id != m.Id || len(got.Question) != 1 || len(got.Answer) != 0 {
+		t.Errorf("slipped response = %v, want the question only", got)
+	}
+
+	stats := rl.stats()
+	if stats.ResponsesDropped != 1 || stats.ResponsesSlipped != 1 {
+		t.Errorf("stats = %+v, want 1 dropped and 1 slipped", stats)
+	}
+}
+
+func TestRRLKey(t *testing.T) {
+	nxdomain := func(name string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		m.Rcode = dns.RcodeNameError
+		return m
+	}
+	ip := net.ParseIP("192.0.2.1")
+
+	// ランダムサブドメインの NXDOMAIN は1つのバケットにまとめる
+	if a, b := rrlKey(ip, nxdomain("x1.u.example."), testOrigin), rrlKey(ip, nxdomain("x2.u.example."), testOrigin); a != b {
+		t.Errorf("nxdomain keys differ: %s, %s", a, b)
+	}
+	// 同じ /24 の送信元は同じバケット
+	if a, b := rrlKey(ip, answerMsg("alice.u.example."), testOrigin), rrlKey(net.ParseIP("192.0.2.200"), answerMsg("alice.u.example."), testOrigin); a != b {
+		t.Errorf("keys in the same prefix differ: %s, %s", a, b)
+	}
+	if a, b := rrlKey(ip, answerMsg("alice.u.example."), testOrigin), rrlKey(net.ParseIP("192.0.3.1"), answerMsg("alice.u.example."), testOrigin); a == b {
+		t.Errorf("keys in different prefixes are the same: %s", a)
+	}
+	// 答えのある応答は名前ごと
+	if a, b := rrlKey(ip, answerMsg("alice.u.example."), testOrigin), rrlKey(ip, answerMsg("bob.u.example."), testOrigin); a == b {
+		t.Errorf("answers for different names share the key %s", a)
+	}
+}
@@ -0,0 +1,519 @@
+// Package dns は u.isucon.dev の権威DNSサーバ
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DefaultOrigin    = "u.isucon.dev."
+	DefaultAddr      = ":53"
+	DefaultRecordTTL = 60
+)
+
+// EDNS0 を使わない UDP クエリの最大応答サイズ
+const minUDPSize = dns.MinMsgSize
+
+type Config struct {
+	// Addr は UDP と TCP の両方で待ち受けるアドレス
+	Addr string
+	Zone *Zone
+	// Store はゾーンファイルのサブドメインとユーザ登録されたサブドメインを保持する
+	Store RecordStore
+	// PublicIP, PublicIPv6 はアドレスの指定がないサブドメインに返すアドレス
+	PublicIP   net.IP
+	PublicIPv6 net.IP
+	// RecordTTL は合成した A / AAAA レコードの TTL
+	RecordTTL uint32
+	RateLimit RateLimitConfig
+	// Forward.Upstream を指定すると、問い合わせを自分で解決せずに転送する
+	Forward ForwardConfig
+	// DNSSEC.KeyDir を指定すると応答に署名する (フォワーダモードでは署名しない)
+	DNSSEC DNSSECConfig
+	// TransferAllow はゾーン転送を許可する送信元
+	TransferAllow []*net.IPNet
+	// Notify はレコードが変わったときに NOTIFY を送るセカンダリ (host:port)
+	Notify []string
+	// QueryLogPath を指定すると、QueryLogSample 件に1件の問い合わせを書き出す
+	QueryLogPath   string
+	QueryLogSample int
+}
+
+type Server struct {
+	addr       string
+	zone       *Zone
+	store      RecordStore
+	publicIP   net.IP
+	publicIPv6 net.IP
+	recordTTL  uint32
+
+	transferAllow []*net.IPNet
+	notify        []string
+
+	limiter   *rateLimiter
+	metrics   *metrics
+	forwarder *forwarder
+	signer    *signer
+
+	mu      sync.Mutex
+	servers []*dns.Server
+	stop    chan struct{}
+}
+
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Zone == nil {
+		return nil, errors.New("zone must be provided")
+	}
+	if cfg.Store == nil {
+		return nil, errors.New("record store must be provided")
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.RecordTTL == 0 {
+		cfg.RecordTTL = DefaultRecordTTL
+	}
+
+	s := &Server{
+		addr:       cfg.Addr,
+		zone:       cfg.Zone,
+		store:      cfg.Store,
+		publicIP:   cfg.PublicIP,
+		publicIPv6: cfg.PublicIPv6,
+		recordTTL:  cfg.RecordTTL,
+
+		transferAllow: cfg.TransferAllow,
+		notify:        cfg.Notify,
+		limiter:       newRateLimiter(cfg.RateLimit),
+		metrics:       newMetrics(),
+		stop:          make(chan struct{}),
+	}
+	if cfg.Forward.Upstream != "" {
+		s.forwarder = newForwarder(cfg.Forward)
+	} else if cfg.DNSSEC.KeyDir != "" {
+		signer, err := newSigner(s.zone.Origin, cfg.DNSSEC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DNSSEC keys: %w", err)
+		}
+		s.signer = signer
+	}
+
+	if cfg.QueryLogPath != "" {
+		if err := s.metrics.openQueryLog(cfg.QueryLogPath, cfg.QueryLogSample, s.stop); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Server) Zone() *Zone {
+	return s.zone
+}
+
+func (s *Server) Store() RecordStore {
+	return s.store
+}
+
+// DS は親ゾーンに登録する DS レコード
+// DNSSEC が無効なら nil
+func (s *Server) DS() *dns.DS {
+	if s.signer == nil {
+		return nil
+	}
+	return s.signer.ds()
+}
+
+func (s *Server) Metrics() Metrics {
+	m := s.metrics.snapshot()
+	m.RateLimit = s.limiter.stats()
+	if s.forwarder != nil {
+		stats := s.forwarder.stats()
+		m.Forward = &stats
+	}
+	return m
+}
+
+func (s *Server) RateLimitStats() RateLimitStats {
+	return s.limiter.stats()
+}
+
+// Listen は UDP と TCP の同じポートで待ち受ける
+// ポートに 0 を指定した場合は UDP で割り当てられたポートを TCP でも使う
+func (s *Server) Listen() error {
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	_, port, err := net.SplitHostPort(pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.servers = []*dns.Server{
+		{PacketConn: pc, Handler: s},
+		{Listener: l, Handler: s},
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Addr は待ち受けているアドレス (UDP)
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.servers) == 0 {
+		return nil
+	}
+	return s.servers[0].PacketConn.LocalAddr()
+}
+
+// Serve は Listen したアドレスで応答を始め、Shutdown されるまでブロックする
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	servers := s.servers
+	s.mu.Unlock()
+	if len(servers) == 0 {
+		return errors.New("dns server is not listening")
+	}
+
+	go s.limiter.sweepPeriodically(s.stop)
+	go s.notifyPeriodically(s.stop)
+
+	errCh := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *dns.Server) {
+			errCh <- server.ActivateAndServe()
+		}(server)
+	}
+
+	var firstErr error
+	for range servers {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			// 片方だけ動いていても意味がないので止める
+			s.shutdownServers(context.Background())
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Shutdown は待ち受けを止める
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	return s.shutdownServers(ctx)
+}
+
+func (s *Server) shutdownServers(ctx context.Context) error {
+	s.mu.Lock()
+	servers := s.servers
+	s.mu.Unlock()
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.ShutdownContext(ctx); err != nil && !strings.Contains(err.Error(), "server not started") {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	m := s.serve(w, r, start)
+	s.metrics.record(w.RemoteAddr(), r, m, time.Since(start))
+}
+
+// serve は問い合わせに応答し、返したメッセージを返す
+// レート制限で応答を捨てた場合は nil
+func (s *Server) serve(w dns.ResponseWriter, r *dns.Msg, now time.Time) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = false
+
+	udpAddr, isUDP := w.RemoteAddr().(*net.UDPAddr)
+	if isUDP && !s.limiter.allowQuery(udpAddr, now) {
+		return nil
+	}
+
+	if isTransfer(r) {
+		return s.transfer(w, r)
+	}
+
+	if r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+		w.WriteMsg(m)
+		return m
+	}
+
+	size, ok := negotiateEDNS0(r, m)
+	if ok {
+		if s.forwarder != nil {
+			m = s.forwarder.forward(r, m, isUDP, now)
+		} else {
+			opt := r.IsEdns0()
+			dnssecOK := s.signer != nil && opt != nil && opt.Do()
+			s.parseQuery(m, dnssecOK)
+			if dnssecOK {
+				s.signResponse(m, now)
+			}
+		}
+	}
+
+	if isUDP {
+		m = s.limiter.limitResponse(udpAddr, m, s.zone.Origin, now)
+		if m == nil {
+			return nil
+		}
+		// UDP で収まらない応答は TC ビットを立てて TCP での再送を促す
+		m.Truncate(int(size))
+	}
+
+	w.WriteMsg(m)
+	return m
+}
+
+// negotiateEDNS0 は問い合わせの OPT レコードを見て応答に OPT を付け、UDP で返せる最大サイズを返す
+func negotiateEDNS0(r, m *dns.Msg) (uint16, bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return minUDPSize, true
+	}
+
+	size := max(opt.UDPSize(), minUDPSize)
+	m.SetEdns0(size, opt.Do())
+	if opt.Version() != 0 {
+		m.Rcode = dns.RcodeBadVers
+		return size, false
+	}
+	return size, true
+}
+
+// parseQuery は問い合わせに答える
+// dnssecOK なら否定応答に NSEC3 を付ける
+func (s *Server) parseQuery(m *dns.Msg, dnssecOK bool) {
+	m.Authoritative = true
+
+	for _, q := range m.Question {
+		name := strings.ToLower(q.Name)
+
+		label, ok := s.zone.label(name)
+		if !ok {
+			// 権威を持たないゾーンの問い合わせは拒否する
+			m.Authoritative = false
+			m.Rcode = dns.RcodeRefused
+			return
+		}
+
+		key, record, found := s.lookupRecord(label)
+		s.metrics.recordLookup(found)
+
+		if !found {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = append(m.Ns, s.negativeSOA())
+			if dnssecOK {
+				m.Ns = append(m.Ns, s.nameErrorProof(label)...)
+			}
+			continue
+		}
+
+		answers := s.records(q.Name, key, record, q.Qtype)
+		if len(answers) == 0 {
+			// NODATA
+			soa := s.negativeSOA()
+			m.Ns = append(m.Ns, soa)
+			if dnssecOK {
+				m.Ns = append(m.Ns, s.signer.matchNSEC3(name, s.types(name, key, record), soa.Hdr.Ttl))
+			}
+			continue
+		}
+		m.Answer = append(m.Answer, answers...)
+
+		// NS のグルーレコード
+		for _, rr := range answers {
+			ns, isNS := rr.(*dns.NS)
+			if !isNS {
+				continue
+			}
+			if glue, inZone := s.zone.label(strings.ToLower(ns.Ns)); inZone {
+				m.Extra = append(m.Extra, s.records(ns.Ns, glue, Record{}, dns.TypeA)...)
+				m.Extra = append(m.Extra, s.records(ns.Ns, glue, Record{}, dns.TypeAAAA)...)
+			}
+		}
+	}
+}
+
+// lookupRecord はサブドメインのレコードを探す
+// 完全一致がなければ、近い親から順にワイルドカード (*.example など) を探す
+// 見つかったキーも返す
+func (s *Server) lookupRecord(label string) (string, Record, bool) {
+	if record, ok := s.store.Lookup(label); ok {
+		return label, record, true
+	}
+
+	for parent := label; parent != ""; {
+		if i := strings.IndexByte(parent, '.'); i >= 0 {
+			parent = parent[i+1:]
+		} else {
+			parent = ""
+		}
+
+		wildcard := "*"
+		if parent != "" {
+			wildcard += "." + parent
+		}
+		if record, ok := s.store.Lookup(wildcard); ok {
+			return wildcard, record, true
+		}
+	}
+
+	return "", Record{}, false
+}
+
+// records は label に登録されたレコードから応答を作る
+// アドレスが指定されていればそれを、なければゾーンファイルのレコードを TTL もそのままで返す
+// ゾーンファイルにないユーザ登録されたサブドメインにだけ A / AAAA を合成して返す
+func (s *Server) records(name string, label string, record Record, qtype uint16) []dns.RR {
+	if record.Address != "" {
+		ip := net.ParseIP(record.Address)
+		return s.addressRecords(name, qtype, ip, ip)
+	}
+
+	answers, inZone := s.zone.lookup(name, label, qtype)
+	if !inZone {
+		answers = s.addressRecords(name, qtype, s.publicIP, s.publicIPv6)
+	}
+	for _, rr := range answers {
+		if soa, ok := rr.(*dns.SOA); ok {
+			soa.Serial = s.store.Serial()
+		}
+	}
+
+	if label == "" && s.signer != nil {
+		if qtype == dns.TypeDNSKEY || qtype == dns.TypeANY {
+			answers = append(answers, s.signer.dnskeys(name)...)
+		}
+		if qtype == dns.TypeNSEC3PARAM || qtype == dns.TypeANY {
+			answers = append(answers, s.signer.nsec3param(name))
+		}
+	}
+	return answers
+}
+
+// soa は現在のシリアルの SOA
+func (s *Server) soa(name string, serial uint32) *dns.SOA {
+	soa := s.zone.soaRecord()
+	soa.Hdr.Name = name
+	soa.Serial = serial
+	return soa
+}
+
+// negativeSOA は否定応答の権威セクションに入れる SOA
+func (s *Server) negativeSOA() *dns.SOA {
+	soa := s.zone.SOA()
+	soa.Serial = s.store.Serial()
+	return soa
+}
+
+// types は名前に存在する型の一覧 (NSEC3 のビットマップ)
+func (s *Server) types(name string, label string, record Record) []uint16 {
+	types := []uint16{dns.TypeRRSIG}
+	for _, rr := range s.records(name, label, record, dns.TypeANY) {
+		if !slices.Contains(types, rr.Header().Rrtype) {
+			types = append(types, rr.Header().Rrtype)
+		}
+	}
+	slices.Sort(types)
+	return types
+}
+
+// nameErrorProof は label が存在しないことを示す NSEC3 を返す
+// 最も近い存在する祖先 (なければゾーン頂点) を最近接存在名とする
+func (s *Server) nameErrorProof(label string) []dns.RR {
+	ttl := s.negativeSOA().Hdr.Ttl
+
+	nextCloser := label
+	for {
+		parent := ""
+		if i := strings.IndexByte(nextCloser, '.'); i >= 0 {
+			parent = nextCloser[i+1:]
+		}
+
+		if record, ok := s.store.Lookup(parent); ok || parent == "" {
+			name := s.zone.name(parent)
+			return s.signer.nameErrorProof(name, s.types(name, parent, record), s.zone.name(nextCloser), ttl)
+		}
+		nextCloser = parent
+	}
+}
+
+// signResponse は応答と権威セクションに署名する
+// 署名に失敗した場合は SERVFAIL にする
+func (s *Server) signResponse(m *dns.Msg, now time.Time) {
+	answer, err := s.signer.sign(m.Answer, now)
+	if err == nil {
+		m.Ns, err = s.signer.sign(m.Ns, now)
+	}
+	if err != nil {
+		m.Answer, m.Ns, m.Extra = nil, nil, nil
+		m.Rcode = dns.RcodeServerFailure
+		return
+	}
+	m.Answer = answer
+}
+
+// addressRecords は name に対する A / AAAA レコードを返す
+func (s *Server) addressRecords(name string, qtype uint16, v4 net.IP, v6 net.IP) []dns.RR {
+	var rrs []dns.RR
+	if (qtype == dns.TypeA || qtype == dns.TypeANY) && v4.To4() != nil {
+		rrs = append(rrs, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: s.recordTTL},
+			A:   v4.To4(),
+		})
+	}
+	if (qtype == dns.TypeAAAA || qtype == dns.TypeANY) && v6 != nil && v6.To4() == nil {
+		rrs = append(rrs, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: s.recordTTL},
+			AAAA: v6,
+		})
+	}
+	return rrs
+}
@@ -0,0 +1,245 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testOrigin = "u.example."
+
+var testZone = func() string {
+	var b strings.Builder
+	b.WriteString(`$TTL 3600
+@   SOA  ns1 hostmaster.u.example. (
+    1      ; serial
+    10800  ; refresh
+    3600   ; retry
+    604800 ; expire
+    300    ; ncache
+)
+@      0 IN NS ns1.u.example.
+@      0 IN A  192.0.2.53
+ns1    0 IN A  192.0.2.53
+www  120 IN A  192.0.2.80
+`)
+	// UDP の 512 バイトに収まらない応答を返す名前
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&b, "big 0 IN A 198.51.100.%d\n", i)
+	}
+	return b.String()
+}()
+
+var (
+	testPublicIP   = net.ParseIP("192.0.2.1")
+	testPublicIPv6 = net.ParseIP("2001:db8::1")
+)
+
+// startTestServer は 127.0.0.1 の空いているポートで DNS サーバを起動し、そのアドレスを返す
+// ゾーンファイルの名前に加えて、ユーザ登録された alice を持つ
+func startTestServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+
+	s := newTestServer(t, cfg)
+	return s, serveTestServer(t, s)
+}
+
+// newTestServer は起動する前のテスト用サーバ
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	zone, err := ParseZone(testZone, testOrigin, "test.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := zone.DefaultRecords()
+	records["alice"] = Record{}
+
+	cfg.Addr = "127.0.0.1:0"
+	cfg.Zone = zone
+	cfg.Store = NewMemoryStore(records)
+	cfg.PublicIP = testPublicIP
+	cfg.PublicIPv6 = testPublicIPv6
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serveTestServer は s を 127.0.0.1 の空いているポートで起動し、そのアドレスを返す
+func serveTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+
+	return s.Addr().String()
+}
+
+func exchange(t *testing.T, network string, addr string, m *dns.Msg) *dns.Msg {
+	t.Helper()
+
+	c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+	r, _, err := c.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func query(name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	return m
+}
+
+func TestServerAnswersAddresses(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  string
+		ttl   uint32
+	}{
+		{"alice.u.example.", dns.TypeA, "192.0.2.1", DefaultRecordTTL},
+		{"alice.u.example.", dns.TypeAAAA, "2001:db8::1", DefaultRecordTTL},
+		{"www.u.example.", dns.TypeA, "192.0.2.80", 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			r := exchange(t, "udp", addr, query(tt.name, tt.qtype))
+			if r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+				t.Fatalf("rcode = %s, aa = %v", dns.RcodeToString[r.Rcode], r.Authoritative)
+			}
+			if len(r.Answer) != 1 {
+				t.Fatalf("answer = %v", r.Answer)
+			}
+
+			var got string
+			switch rr := r.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.AAAA:
+				got = rr.AAAA.String()
+			}
+			if got != tt.want || r.Answer[0].Header().Ttl != tt.ttl {
+				t.Errorf("answer = %s, want %s with ttl %d", r.Answer[0], tt.want, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestServerNoAAAAForZoneNames(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	// ゾーンファイルに AAAA のない名前は NODATA
+	r := exchange(t, "udp", addr, query("www.u.example.", dns.TypeAAAA))
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 {
+		t.Fatalf("rcode = %s, answer = %v", dns.RcodeToString[r.Rcode], r.Answer)
+	}
+	if len(r.Ns) != 1 || r.Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("authority = %v", r.Ns)
+	}
+}
+
+func TestServerNameError(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	r := exchange(t, "udp", addr, query("nobody.u.example.", dns.TypeA))
+	if r.Rcode != dns.RcodeNameError || !r.Authoritative {
+		t.Fatalf("rcode = %s, aa = %v", dns.RcodeToString[r.Rcode], r.Authoritative)
+	}
+	if len(r.Answer) != 0 {
+		t.Errorf("answer = %v", r.Answer)
+	}
+	if len(r.Ns) != 1 {
+		t.Fatalf("authority = %v", r.Ns)
+	}
+	soa, ok := r.Ns[0].(*dns.SOA)
+	if !ok || soa.Hdr.Name != testOrigin {
+		t.Fatalf("authority = %v, want SOA of %s", r.Ns[0], testOrigin)
+	}
+	// 否定応答の TTL は min(SOA の TTL, MINIMUM)
+	if soa.Hdr.Ttl != 300 {
+		t.Errorf("negative ttl = %d, want 300", soa.Hdr.Ttl)
+	}
+}
+
+func TestServerRefusesOutOfZone(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	r := exchange(t, "udp", addr, query("www.example.com.", dns.TypeA))
+	if r.Rcode != dns.RcodeRefused {
+		t.Fatalf("rcode = %s, want REFUSED", dns.RcodeToString[r.Rcode])
+	}
+	if r.Authoritative || len(r.Answer) != 0 {
+		t.Errorf("aa = %v, answer = %v", r.Authoritative, r.Answer)
+	}
+}
+
+func TestServerNSGlue(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	r := exchange(t, "udp", addr, query(testOrigin, dns.TypeNS))
+	if len(r.Answer) != 1 {
+		t.Fatalf("answer = %v", r.Answer)
+	}
+	// グルーはゾーンファイルの A だけで、TTL もゾーンファイルのまま
+	if len(r.Extra) != 1 {
+		t.Fatalf("extra = %v", r.Extra)
+	}
+	glue, ok := r.Extra[0].(*dns.A)
+	if !ok || glue.Hdr.Name != "ns1.u.example." || glue.Hdr.Ttl != 0 {
+		t.Errorf("glue = %v", r.Extra[0])
+	}
+}
+
+func TestServerTCP(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	r := exchange(t, "tcp", addr, query("big.u.example.", dns.TypeA))
+	if r.Truncated {
+		t.Error("tcp answer is truncated")
+	}
+	if len(r.Answer) != 40 {
+		t.Errorf("got %d answers over tcp, want 40", len(r.Answer))
+	}
+}
+
+func TestServerTruncatesUDP(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	// EDNS0 がなければ 512 バイトまで
+	r := exchange(t, "udp", addr, query("big.u.example.", dns.TypeA))
+	if !r.Truncated {
+		t.Error("TC bit is not set on an oversized udp answer")
+	}
+	// 切り詰めるときは名前を圧縮するので、圧縮した大きさで比べる
+	r.Compress = true
+	if r.Len() > dns.MinMsgSize {
+		t.Errorf("udp answer is %d bytes, want at most %d", r.Len(), dns.MinMsgSize)
+	}
+
+	// EDNS0 で大きなバッファを広告すれば切り詰めない
+	m := query("big.u.example.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	r = exchange(t, "udp", addr, m)
+	if r.Truncated || len(r.Answer) != 40 {
+		t.Errorf("tc = %v, answers = %d with edns0", r.Truncated, len(r.Answer))
+	}
+}
@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// 変更履歴 (IXFR 用) として保持する件数
+const maxChanges = 4096
+
+// Record はサブドメインごとのレコード
+// Address が空ならゾーンファイルのレコード、なければサーバの公開アドレスを返す
+type Record struct {
+	Address string `json:"address,omitempty"`
+}
+
+// Change はレコードの1回の変更
+type Change struct {
+	// Serial は変更後のシリアル
+	Serial uint32
+	Name   string
+	// Old が nil なら追加、New が nil なら削除
+	Old *Record
+	New *Record
+}
+
+// RecordStore はサブドメインのレコードを保持する
+// キーはゾーン名を除いた小文字のサブドメイン名で、先頭が "*" のものはワイルドカード
+type RecordStore interface {
+	Lookup(name string) (Record, bool)
+	Put(name string, record Record) error
+	// Add は name が登録されていない場合だけ追加し、追加したかどうかを返す
+	Add(name string, record Record) (bool, error)
+	Delete(name string) (bool, error)
+	List() map[string]Record
+	// Reset はすべてのレコードを records で置き換える
+	Reset(records map[string]Record) error
+
+	// Serial はゾーンのシリアルで、変更のたびに1ずつ増える
+	Serial() uint32
+	// Changes は from より後の変更を返す
+	// 履歴が残っていない場合は false
+	Changes(from uint32) ([]Change, bool)
+	// Changed は変更があると通知されるチャネル (受け取り手は1つだけ)
+	Changed() <-chan struct{}
+}
+
+// MemoryStore はプロセス内だけで保持する RecordStore
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+	serial  uint32
+	changes []Change
+	changed chan struct{}
+}
+
+var _ RecordStore = (*MemoryStore)(nil)
+
+// NewMemoryStore はシリアルを現在時刻 (UNIX 時間) から始める
+func NewMemoryStore(records map[string]Record) *MemoryStore {
+	s := &MemoryStore{
+		serial:  uint32(time.Now().Unix()),
+		changed: make(chan struct{}, 1),
+	}
+	s.reset(records)
+	return s
+}
+
+func (s *MemoryStore) Lookup(name string) (Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[name]
+	return record, ok
+}
+
+func (s *MemoryStore) Put(name string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change := Change{Name: name, New: &record}
+	if old, ok := s.records[name]; ok {
+		change.Old = &old
+	}
+	s.records[name] = record
+	s.commit(change)
+	return nil
+}
+
+func (s *MemoryStore) Add(name string, record Record) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[name]; ok {
+		return false, nil
+	}
+	s.records[name] = record
+	s.commit(Change{Name: name, New: &record})
+	return true, nil
+}
+
+func (s *MemoryStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.records[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.records, name)
+	s.commit(Change{Name: name, Old: &old})
+	return true, nil
+}
+
+func (s *MemoryStore) List() map[string]Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make(map[string]Record, len(s.records))
+	for name, record := range s.records {
+		records[name] = record
+	}
+	return records
+}
+
+func (s *MemoryStore) Reset(records map[string]Record) error {
+	s.reset(records)
+	return nil
+}
+
+// reset はレコードを置き換える
+// 差分は記録しないので、これより前のシリアルからの IXFR は AXFR になる
+func (s *MemoryStore) reset(records map[string]Record) {
+	m := make(map[string]Record, len(records))
+	for name, record := range records {
+		m[name] = record
+	}
+
+	s.mu.Lock()
+	s.records = m
+	s.serial++
+	s.changes = nil
+	s.notify()
+	s.mu.Unlock()
+}
+
+func (s *MemoryStore) Serial() uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.serial
+}
+
+// setSerial はジャーナルから復元したシリアルに合わせる
+func (s *MemoryStore) setSerial(serial uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.serial = serial
+	s.changes = nil
+}
+
+func (s *MemoryStore) Changes(from uint32) ([]Change, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from == s.serial {
+		return nil, true
+	}
+	if len(s.changes) == 0 {
+		return nil, false
+	}
+
+	// シリアルは1ずつ増えるので、from の次の変更の位置は差で求まる
+	i := from - (s.changes[0].Serial - 1)
+	if i >= uint32(len(s.changes)) {
+		return nil, false
+	}
+	return append([]Change(nil), s.changes[i:]...), true
+}
+
+func (s *MemoryStore) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// commit はシリアルを進めて変更を履歴に残す
+// s.mu を書き込みロックした状態で呼ぶ
+func (s *MemoryStore) commit(change Change) {
+	s.serial++
+	change.Serial = s.serial
+
+	if len(s.changes) >= maxChanges {
+		s.changes = append(s.changes[:0], s.changes[len(s.changes)-maxChanges+1:]...)
+	}
+	s.changes = append(s.changes, change)
+	s.notify()
+}
+
+func (s *MemoryStore) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ゾーン転送 (AXFR / IXFR) と NOTIFY
+// 他のノードのセカンダリ DNS がユーザ登録されたサブドメインを取り込めるようにする
+// 転送はTCPだけで、Config.TransferAllow に含まれる送信元だけに許可する
+// 署名はオンラインで行うので、転送するゾーンには RRSIG / DNSKEY / NSEC3 を含めない
+
+const (
+	// 1つのメッセージに詰めるレコード数
+	transferChunkSize = 256
+
+	notifyTimeout = 2 * time.Second
+	notifyRetries = 3
+)
+
+func isTransfer(r *dns.Msg) bool {
+	return r.Opcode == dns.OpcodeQuery && len(r.Question) == 1 &&
+		(r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR)
+}
+
+func (s *Server) allowTransfer(addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return false
+	}
+
+	for _, n := range s.transferAllow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// transfer はゾーン転送の問い合わせに応答する
+// 返すメッセージはメトリクス用で、実際にはいくつかのメッセージに分けて書き込む
+func (s *Server) transfer(w dns.ResponseWriter, r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	q := r.Question[0]
+	_, isUDP := w.RemoteAddr().(*net.UDPAddr)
+	if !dns.IsSubDomain(q.Name, s.zone.Origin) || !dns.IsSubDomain(s.zone.Origin, q.Name) {
+		m.Authoritative = false
+		m.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(m)
+		return m
+	}
+	if s.forwarder != nil || !s.allowTransfer(w.RemoteAddr()) || (isUDP && q.Qtype == dns.TypeAXFR) {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return m
+	}
+
+	soa := s.soa(q.Name, s.store.Serial())
+
+	// UDP の IXFR には SOA だけを返して TCP で問い合わせ直してもらう (RFC 1995 4)
+	if isUDP {
+		m.Answer = []dns.RR{soa}
+		w.WriteMsg(m)
+		return m
+	}
+
+	var rrs []dns.RR
+	if q.Qtype == dns.TypeIXFR {
+		rrs = s.incrementalTransfer(r, soa)
+	}
+	if rrs == nil {
+		rrs = s.fullTransfer(soa)
+	}
+
+	for i := 0; i < len(rrs); i += transferChunkSize {
+		chunk := new(dns.Msg)
+		chunk.SetReply(r)
+		chunk.Authoritative = true
+		chunk.Answer = rrs[i:min(i+transferChunkSize, len(rrs))]
+		if err := w.WriteMsg(chunk); err != nil {
+			log.Printf("failed to write zone transfer to %s: %+v\n", w.RemoteAddr(), err)
+			break
+		}
+	}
+	return m
+}
+
+// fullTransfer は AXFR 形式のレコード (SOA, 全レコード, SOA) を返す
+func (s *Server) fullTransfer(soa *dns.SOA) []dns.RR {
+	records := s.store.List()
+	labels := make([]string, 0, len(records))
+	for label := range records {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	rrs := []dns.RR{soa}
+	for _, label := range labels {
+		rrs = append(rrs, s.transferRecords(label, records[label])...)
+	}
+	return append(rrs, soa)
+}
+
+// incrementalTransfer は IXFR 形式のレコードを返す
+// 相手のシリアルからの差分が残っていない場合は nil (AXFR で返す)
+func (s *Server) incrementalTransfer(r *dns.Msg, soa *dns.SOA) []dns.RR {
+	if len(r.Ns) == 0 {
+		return nil
+	}
+	clientSOA, ok := r.Ns[0].(*dns.SOA)
+	if !ok {
+		return nil
+	}
+
+	// 最新なら SOA だけを返す
+	if clientSOA.Serial == soa.Serial {
+		return []dns.RR{soa}
+	}
+
+	changes, ok := s.store.Changes(clientSOA.Serial)
+	if !ok || len(changes) == 0 || changes[len(changes)-1].Serial != soa.Serial {
+		return nil
+	}
+
+	rrs := []dns.RR{soa}
+	for _, change := range changes {
+		rrs = append(rrs, s.soa(soa.Hdr.Name, change.Serial-1))
+		if change.Old != nil {
+			rrs = append(rrs, s.transferRecords(change.Name, *change.Old)...)
+		}
+		rrs = append(rrs, s.soa(soa.Hdr.Name, change.Serial))
+		if change.New != nil {
+			rrs = append(rrs, s.transferRecords(change.Name, *change.New)...)
+		}
+	}
+	return append(rrs, soa)
+}
+
+// transferRecords は label のレコードのうち転送するもの
+func (s *Server) transferRecords(label string, record Record) []dns.RR {
+	var rrs []dns.RR
+	for _, rr := range s.records(s.zone.name(label), label, record, dns.TypeANY) {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA, dns.TypeDNSKEY, dns.TypeNSEC3PARAM:
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// notifyPeriodically はレコードが変わるたびにセカンダリへ NOTIFY を送る
+// 続けて変更があった場合はまとめて1回だけ送る
+func (s *Server) notifyPeriodically(stop <-chan struct{}) {
+	if len(s.notify) == 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.store.Changed():
+		}
+
+		serial := s.store.Serial()
+		for _, addr := range s.notify {
+			if err := s.sendNotify(addr, serial); err != nil {
+				log.Printf("failed to send NOTIFY to %s: %+v\n", addr, err)
+			}
+		}
+	}
+}
+
+func (s *Server) sendNotify(addr string, serial uint32) error {
+	m := new(dns.Msg)
+	m.SetNotify(s.zone.Origin)
+	m.Answer = []dns.RR{s.soa(s.zone.Origin, serial)}
+
+	c := &dns.Client{Net: "udp", Timeout: notifyTimeout}
+	var err error
+	for i := 0; i < notifyRetries; i++ {
+		var res *dns.Msg
+		res, _, err = c.Exchange(m, addr)
+		if err != nil {
+			continue
+		}
+		if res.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("NOTIFY was answered with %s", dns.RcodeToString[res.Rcode])
+		}
+		return nil
+	}
+	return err
+}
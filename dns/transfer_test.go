@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var testTransferAllow = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+
+// zoneTransfer は TCP でゾーン転送を受け取り、すべてのメッセージの Answer をつなげて返す
+func zoneTransfer(t *testing.T, addr string, m *dns.Msg) []dns.RR {
+	t.Helper()
+
+	tr := &dns.Transfer{DialTimeout: 5 * time.Second, ReadTimeout: 5 * time.Second}
+	envelopes, err := tr.In(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rrs []dns.RR
+	for env := range envelopes {
+		if env.Error != nil {
+			t.Fatal(env.Error)
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	return rrs
+}
+
+func axfr() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetAxfr(testOrigin)
+	return m
+}
+
+func ixfr(serial uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetIxfr(testOrigin, serial, "ns1."+testOrigin, "hostmaster."+testOrigin)
+	return m
+}
+
+func soaSerial(t *testing.T, rr dns.RR) uint32 {
+	t.Helper()
+
+	soa, ok := rr.(*dns.SOA)
+	if !ok {
+		t.Fatalf("%s is not SOA", rr)
+	}
+	return soa.Serial
+}
+
+// hasRecord は rrs に name の qtype のレコードがあるか調べる
+func hasRecord(rrs []dns.RR, name string, qtype uint16) bool {
+	for _, rr := range rrs {
+		if strings.EqualFold(rr.Header().Name, name) && rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// isFullTransfer は SOA で始まり SOA で終わり、間に SOA のない AXFR 形式か調べる
+func isFullTransfer(t *testing.T, rrs []dns.RR, serial uint32) bool {
+	t.Helper()
+
+	if len(rrs) < 3 || soaSerial(t, rrs[0]) != serial || soaSerial(t, rrs[len(rrs)-1]) != serial {
+		return false
+	}
+	return !hasRecord(rrs[1:len(rrs)-1], testOrigin, dns.TypeSOA)
+}
+
+func TestAXFR(t *testing.T) {
+	s, addr := startTestServer(t, Config{TransferAllow: testTransferAllow})
+
+	rrs := zoneTransfer(t, addr, axfr())
+	if !isFullTransfer(t, rrs, s.Store().Serial()) {
+		t.Fatalf("AXFR = %v, want SOA, records, SOA with serial %d", rrs, s.Store().Serial())
+	}
+	for _, want := range []struct {
+		name  string
+		qtype uint16
+	}{
+		{testOrigin, dns.TypeNS},
+		{"www.u.example.", dns.TypeA},
+		{"alice.u.example.", dns.TypeA},
+		{"alice.u.example.", dns.TypeAAAA},
+	} {
+		if !hasRecord(rrs, want.name, want.qtype) {
+			t.Errorf("AXFR has no %s %s", want.name, dns.TypeToString[want.qtype])
+		}
+	}
+}
+
+func TestTransferRefused(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+
+	// 許可していない送信元
+	r := exchange(t, "tcp", addr, axfr())
+	if r.Rcode != dns.RcodeRefused {
+		t.Errorf("AXFR from a disallowed source: rcode = %s, want REFUSED", dns.RcodeToString[r.Rcode])
+	}
+
+	// UDP の AXFR
+	_, addr = startTestServer(t, Config{TransferAllow: testTransferAllow})
+	r = exchange(t, "udp", addr, axfr())
+	if r.Rcode != dns.RcodeRefused {
+		t.Errorf("AXFR over udp: rcode = %s, want REFUSED", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func TestIXFR(t *testing.T) {
+	s, addr := startTestServer(t, Config{TransferAllow: testTransferAllow})
+	store := s.Store()
+	from := store.Serial()
+	store.Put("bob", Record{})
+	store.Delete("alice")
+	to := store.Serial()
+
+	// RFC 1995 4: SOA(新), [SOA(旧), 削除したレコード, SOA(新), 追加したレコード]..., SOA(新)
+	rrs := zoneTransfer(t, addr, ixfr(from))
+	var soas []uint32
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			soas = append(soas, soaSerial(t, rr))
+		}
+	}
+	want := []uint32{to, from, from + 1, from + 1, to, to}
+	if len(soas) != len(want) {
+		t.Fatalf("IXFR SOA serials = %v, want %v", soas, want)
+	}
+	for i := range want {
+		if soas[i] != want[i] {
+			t.Fatalf("IXFR SOA serials = %v, want %v", soas, want)
+		}
+	}
+	if !hasRecord(rrs, "bob.u.example.", dns.TypeA) || !hasRecord(rrs, "alice.u.example.", dns.TypeA) {
+		t.Errorf("IXFR = %v, want bob added and alice deleted", rrs)
+	}
+	// 変わっていない名前は含めない
+	if hasRecord(rrs, "www.u.example.", dns.TypeA) {
+		t.Errorf("IXFR has unchanged www: %v", rrs)
+	}
+
+	// 最新のシリアルからなら SOA だけ
+	r := exchange(t, "tcp", addr, ixfr(to))
+	if len(r.Answer) != 1 || soaSerial(t, r.Answer[0]) != to {
+		t.Errorf("IXFR from the current serial = %v, want the SOA only", r.Answer)
+	}
+}
+
+func TestIXFRFallsBackToAXFR(t *testing.T) {
+	s, addr := startTestServer(t, Config{TransferAllow: testTransferAllow})
+	store := s.Store()
+	from := store.Serial()
+	store.Put("bob", Record{})
+
+	// 知らないシリアル
+	rrs := zoneTransfer(t, addr, ixfr(from-100))
+	if !isFullTransfer(t, rrs, store.Serial()) || !hasRecord(rrs, "www.u.example.", dns.TypeA) {
+		t.Errorf("IXFR from an unknown serial = %v, want AXFR", rrs)
+	}
+
+	// Reset で履歴が消えたシリアル
+	latest := store.Serial()
+	if err := store.Reset(store.List()); err != nil {
+		t.Fatal(err)
+	}
+	rrs = zoneTransfer(t, addr, ixfr(latest))
+	if !isFullTransfer(t, rrs, store.Serial()) || !hasRecord(rrs, "bob.u.example.", dns.TypeA) {
+		t.Errorf("IXFR from a compacted serial = %v, want AXFR", rrs)
+	}
+}
+
+func TestSerialIncreasesOnChange(t *testing.T) {
+	store := NewMemoryStore(map[string]Record{"www": {}})
+	serial := store.Serial()
+
+	steps := []struct {
+		name    string
+		change  func()
+		changed bool
+	}{
+		{"put", func() { store.Put("alice", Record{}) }, true},
+		{"overwrite", func() { store.Put("alice", Record{Address: "192.0.2.10"}) }, true},
+		{"add existing", func() { store.Add("alice", Record{}) }, false},
+		{"add", func() { store.Add("bob", Record{}) }, true},
+		{"delete", func() { store.Delete("alice") }, true},
+		{"delete missing", func() { store.Delete("alice") }, false},
+	}
+	for _, step := range steps {
+		step.change()
+		want := serial
+		if step.changed {
+			want++
+		}
+		if got := store.Serial(); got != want {
+			t.Errorf("%s: serial = %d, want %d", step.name, got, want)
+		}
+		serial = store.Serial()
+	}
+
+	changes, ok := store.Changes(serial - 4)
+	if !ok || len(changes) != 4 {
+		t.Fatalf("Changes = %v, %v, want 4 changes", changes, ok)
+	}
+	if c := changes[3]; c.Name != "alice" || c.Old == nil || c.New != nil || c.Serial != serial {
+		t.Errorf("last change = %+v, want delete of alice at %d", c, serial)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	notified := make(chan uint32, 8)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Opcode == dns.OpcodeNotify && len(r.Answer) == 1 {
+			if soa, ok := r.Answer[0].(*dns.SOA); ok {
+				notified <- soa.Serial
+			}
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})}
+	go secondary.ActivateAndServe()
+	t.Cleanup(func() { secondary.Shutdown() })
+
+	s, _ := startTestServer(t, Config{Notify: []string{pc.LocalAddr().String()}})
+	s.Store().Put("bob", Record{})
+	want := s.Store().Serial()
+
+	for {
+		select {
+		case serial := <-notified:
+			if serial == want {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no NOTIFY with serial %d", want)
+		}
+	}
+}
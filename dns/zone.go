@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneAddressPlaceholder は init_zone.sh と同じく、ゾーンファイル中でサブドメインのアドレスに置き換える文字列
+const ZoneAddressPlaceholder = "<ISUCON_SUBDOMAIN_ADDRESS>"
+
+// Zone はゾーンファイルから読み込んだレコードをサブドメイン (ラベル) ごとにまとめたもの
+// 読み込んだあとは変更しない
+type Zone struct {
+	Origin  string
+	records map[string][]dns.RR
+	soa     *dns.SOA
+}
+
+// LoadZoneFile はゾーンファイルを読み込み、プレースホルダを address に置き換えてパースする
+func LoadZoneFile(path string, origin string, address string) (*Zone, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := strings.ReplaceAll(string(b), ZoneAddressPlaceholder, address)
+	return ParseZone(zone, origin, path)
+}
+
+func ParseZone(zone string, origin string, path string) (*Zone, error) {
+	z := &Zone{
+		Origin:  dns.CanonicalName(origin),
+		records: map[string][]dns.RR{},
+	}
+
+	zp := dns.NewZoneParser(strings.NewReader(zone), z.Origin, path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rr.Header().Name = strings.ToLower(rr.Header().Name)
+
+		label, inZone := z.label(rr.Header().Name)
+		if !inZone {
+			return nil, fmt.Errorf("record %s is out of zone %s", rr.Header().Name, z.Origin)
+		}
+
+		if soa, isSOA := rr.(*dns.SOA); isSOA {
+			z.soa = soa
+		}
+		z.records[label] = append(z.records[label], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	if z.soa == nil {
+		return nil, fmt.Errorf("zone %s has no SOA record", z.Origin)
+	}
+
+	return z, nil
+}
+
+// label はゾーン配下の名前からサブドメイン部分を取り出す
+func (z *Zone) label(name string) (string, bool) {
+	if !dns.IsSubDomain(z.Origin, name) {
+		return "", false
+	}
+	label := strings.TrimSuffix(name, z.Origin)
+	return strings.TrimSuffix(label, "."), true
+}
+
+// name は label の完全修飾名
+func (z *Zone) name(label string) string {
+	if label == "" {
+		return z.Origin
+	}
+	return label + "." + z.Origin
+}
+
+// Labels はゾーンファイルに存在するサブドメインの一覧
+func (z *Zone) Labels() []string {
+	labels := make([]string, 0, len(z.records))
+	for label := range z.records {
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// DefaultRecords はゾーンファイルのサブドメインをレコードストアの初期値にしたもの
+func (z *Zone) DefaultRecords() map[string]Record {
+	records := make(map[string]Record, len(z.records))
+	for label := range z.records {
+		records[label] = Record{}
+	}
+	return records
+}
+
+func (z *Zone) soaRecord() *dns.SOA {
+	return dns.Copy(z.soa).(*dns.SOA)
+}
+
+// SOA は否定応答の権威セクションに入れる SOA を返す
+// シリアルはゾーンファイルのもの
+func (z *Zone) SOA() *dns.SOA {
+	soa := z.soaRecord()
+	// 否定応答のTTLは min(SOAのTTL, MINIMUM) になる (RFC 2308)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	return soa
+}
+
+// lookup はゾーンファイル由来のレコードのうち qtype に一致するものを name で返す
+// ゾーンファイルに存在しないラベルの場合は ok = false
+func (z *Zone) lookup(name string, label string, qtype uint16) ([]dns.RR, bool) {
+	rrs, ok := z.records[label]
+	if !ok {
+		return nil, false
+	}
+
+	var answers []dns.RR
+	for _, rr := range rrs {
+		if qtype != dns.TypeANY && rr.Header().Rrtype != qtype {
+			continue
+		}
+		answer := dns.Copy(rr)
+		answer.Header().Name = name
+		answers = append(answers, answer)
+	}
+	return answers, true
+}
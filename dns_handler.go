@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	dnsserver "github.com/isucon/isucon13/webapp/go/dns"
+	"github.com/labstack/echo/v4"
+	"github.com/miekg/dns"
+)
+
+const dnsCompactInterval = 30 * time.Second
+
+// 登録したユーザのレコードの追加に失敗したときに追加し直す回数と、最初の間隔 (倍々に延ばす)
+const (
+	dnsPutRetries       = 5
+	dnsPutRetryInterval = 1 * time.Second
+)
+
+var (
+	dnsZone   *dnsserver.Zone
+	dnsStore  *dnsserver.JournalStore
+	dnsServer *dnsserver.Server
+)
+
+// startDNSServer はゾーンファイルとレコードを読み込んで DNS サーバを起動する
+// 返す関数で停止する
+func startDNSServer() (func(ctx context.Context) error, error) {
+	cfg, zoneFile, err := dnsserver.ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// init_zone.sh と同じく、未設定なら 127.0.0.1 を埋め込む
+	address := "127.0.0.1"
+	if cfg.PublicIP != nil {
+		address = cfg.PublicIP.String()
+	}
+	zone, err := dnsserver.LoadZoneFile(zoneFile, dnsserver.DefaultOrigin, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DNS zone file '%s': %w", zoneFile, err)
+	}
+
+	store, err := dnsserver.OpenJournalStore(".", zone.DefaultRecords(), dnsCompactInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Zone = zone
+	cfg.Store = store
+	server, err := dnsserver.NewServer(cfg)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if err := server.Listen(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to listen DNS on %s: %w", cfg.Addr, err)
+	}
+
+	dnsZone = zone
+	dnsStore = store
+	dnsServer = server
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Fatalf("failed to serve DNS: %+v\n", err)
+		}
+	}()
+	log.Printf("Starting DNS server at %s\n", server.Addr())
+	if cfg.Forward.Upstream != "" {
+		log.Printf("Forwarding DNS queries to %s\n", cfg.Forward.Upstream)
+	}
+	if ds := server.DS(); ds != nil {
+		log.Printf("DNSSEC enabled. DS record for the parent zone: %s\n", ds)
+	}
+
+	return func(ctx context.Context) error {
+		return errors.Join(server.Shutdown(ctx), store.Close())
+	}, nil
+}
+
+// retryPutUserDNSRecord はユーザのサブドメインのレコードを追加できるまで間隔を空けて追加し直す
+// 待っている間にユーザが削除された場合は追加しない
+func retryPutUserDNSRecord(name string) {
+	wait := dnsPutRetryInterval
+	for i := 0; i < dnsPutRetries; i++ {
+		time.Sleep(wait)
+		wait *= 2
+
+		var count int
+		if err := dbConn.Get(&count, "SELECT COUNT(*) FROM users WHERE name = ?", name); err != nil {
+			log.Printf("failed to check user %s before adding dns record: %+v\n", name, err)
+			continue
+		}
+		if count == 0 {
+			return
+		}
+		if _, err := dnsStore.Add(name, dnsserver.Record{}); err != nil {
+			log.Printf("failed to add dns record of user %s: %+v\n", name, err)
+			continue
+		}
+		return
+	}
+	log.Printf("gave up adding dns record of user %s\n", name)
+}
+
+// DNSサーバのメトリクス
+// GET /api/dns/metrics
+func getDNSMetricsHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, dnsServer.Metrics())
+}
+
+type DNSRecord struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+type PutDNSRecordRequest struct {
+	// Address が空ならゾーンファイルのレコードか ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS を返す
+	Address string `json:"address" validate:"ip"`
+}
+
+// normalizeDNSRecordName はサブドメイン名を小文字にして検証する
+// ワイルドカードは先頭のラベルが "*" の場合だけ許可する
+func normalizeDNSRecordName(name string) (string, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if strings.Contains(label, "*") && (i != 0 || label != "*") {
+			return "", fmt.Errorf("wildcard is only allowed as the leftmost label: %s", name)
+		}
+	}
+
+	if _, ok := dns.IsDomainName(name + "." + dnsZone.Origin); !ok {
+		return "", fmt.Errorf("invalid domain name: %s", name)
+	}
+	return name, nil
+}
+
+// DNSレコード一覧API
+// GET /api/dns/records
+func getDNSRecordsHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	entries := dnsStore.List()
+	records := make([]DNSRecord, 0, len(entries))
+	for name, record := range entries {
+		records = append(records, DNSRecord{Name: name, Address: record.Address})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+
+	return c.JSON(http.StatusOK, records)
+}
+
+// DNSレコード登録・更新API
+// PUT /api/dns/records/:name
+func putDNSRecordHandler(c echo.Context) error {
+	defer c.Request().Body.Close()
+
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	name, err := normalizeDNSRecordName(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	req := PutDNSRecordRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+
+	if err := dnsStore.Put(name, dnsserver.Record{Address: req.Address}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to add dns record: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, DNSRecord{Name: name, Address: req.Address})
+}
+
+// DNSレコード削除API
+// DELETE /api/dns/records/:name
+func deleteDNSRecordHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	name, err := normalizeDNSRecordName(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	found, err := dnsStore.Delete(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete dns record: "+err.Error())
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "dns record not found")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
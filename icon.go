@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	_ "golang.org/x/image/webp"
+)
+
+// アイコン画像の加工
+// アップロードされた画像は先頭のバイト列で形式を判定し、正方形に切り抜いて iconCfg.Size 四方に縮小してから保存する
+// JPEG は JPEG のまま、PNG と GIF は PNG にする (アニメーションGIFは最初のコマだけ)
+// WebP は x/image/webp で復号し、書き出す方法がないので PNG にする
+// ?size= の縮小版は初めて要求されたときに作り、thumbnails ディレクトリに置いておく
+
+const (
+	iconMaxBytesEnvKey = "ISUCON13_ICON_MAX_BYTES"
+	iconSizeEnvKey     = "ISUCON13_ICON_SIZE"
+
+	defaultIconMaxBytes = 10 << 20
+	defaultIconSize     = 256
+	// 展開すると巨大になる画像を復号しないよう、縦横の画素数で上限を設ける
+	iconMaxPixels   = 8192 * 8192
+	iconJPEGQuality = 90
+
+	iconThumbnailDir = "thumbnails"
+)
+
+var (
+	iconCfg = iconConfig{MaxBytes: defaultIconMaxBytes, Size: defaultIconSize}
+
+	// ?size= で指定できる大きさ
+	iconThumbnailSizes = []int{32, 64, 128}
+)
+
+type iconConfig struct {
+	// MaxBytes はアップロードできる画像のバイト数の上限
+	MaxBytes int
+	// Size は保存する画像の一辺の画素数。0 なら縮小も変換もせずにそのまま保存する
+	Size int
+}
+
+func iconConfigFromEnv() (iconConfig, error) {
+	cfg := iconCfg
+	if v, ok := os.LookupEnv(iconMaxBytesEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", iconMaxBytesEnvKey, v)
+		}
+		cfg.MaxBytes = n
+	}
+	if v, ok := os.LookupEnv(iconSizeEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as non-negative integer: %s", iconSizeEnvKey, v)
+		}
+		cfg.Size = n
+	}
+	return cfg, nil
+}
+
+var (
+	errIconUnsupportedFormat = errors.New("image must be JPEG, PNG, GIF or WebP")
+	errIconTooLarge          = errors.New("image is too large")
+)
+
+// sniffIconType は先頭のバイト列から画像の Content-Type を判定する
+// 対応していない形式なら空文字列
+func sniffIconType(data []byte) string {
+	switch t := http.DetectContentType(data); t {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return t
+	}
+	return ""
+}
+
+// processIcon はアップロードされた画像を検証し、保存するバイト列にする
+func (cfg iconConfig) processIcon(data []byte) ([]byte, error) {
+	contentType := sniffIconType(data)
+	if contentType == "" {
+		return nil, errIconUnsupportedFormat
+	}
+
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if conf.Width*conf.Height > iconMaxPixels {
+		return nil, errIconTooLarge
+	}
+	// 縮小しない場合はヘッダまで確かめてそのまま保存する
+	if cfg.Size == 0 {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return encodeIcon(resizeIcon(img, cfg.Size), contentType)
+}
+
+// encodeIcon は JPEG なら JPEG、それ以外は PNG で書き出す
+func encodeIcon(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: iconJPEGQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeIcon は画像の中央を正方形に切り抜き、size 四方にする
+// 縮小は面積平均、拡大は最近傍になる
+func resizeIcon(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	src := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(src, src.Bounds(), img, crop.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := y * side / size
+		y1 := max((y+1)*side/size, y0+1)
+		for x := 0; x < size; x++ {
+			x0 := x * side / size
+			x1 := max((x+1)*side/size, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for i := 0; i < 4; i++ {
+						sum[i] += int(row[sx*4+i])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			for i := 0; i < 4; i++ {
+				dst.Pix[y*dst.Stride+x*4+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// iconThumbnail は path の画像を size 四方に縮小した画像のパスを返す
+// 縮小版は prefix と画像のハッシュの名前で thumbnails ディレクトリに保存しておき、次からはそれを返す
+// ハッシュは読み込んだ画像から求めるので、アイコンを変えた直後でも古い縮小版を返さない
+// 復号できない画像は元の画像のパスを返す
+func iconThumbnail(path string, prefix string, size int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	thumbnailPath := filepath.Join(iconDir, iconThumbnailDir, fmt.Sprintf("%s_%x_%d", prefix, sha256.Sum256(data), size))
+	if _, err := os.Stat(thumbnailPath); err == nil {
+		return thumbnailPath, nil
+	}
+
+	contentType := sniffIconType(data)
+	if contentType == "" {
+		return path, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	thumbnail, err := encodeIcon(resizeIcon(img, size), contentType)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbnailPath), 0755); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(thumbnailPath, thumbnail); err != nil {
+		return "", err
+	}
+	return thumbnailPath, nil
+}
+
+// removeIconThumbnails はユーザのアイコンの縮小版を消す
+func removeIconThumbnails(userID int64) error {
+	paths, err := filepath.Glob(filepath.Join(iconDir, iconThumbnailDir, fmt.Sprintf("%d_*", userID)))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic は書きかけのファイルを読まれないよう、一時ファイルに書いてから置き換える
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// parseIconSize は ?size= の値を検証する。指定がなければ 0
+func parseIconSize(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err == nil {
+		for _, s := range iconThumbnailSizes {
+			if size == s {
+				return size, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("size must be one of %v", iconThumbnailSizes)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessIcon(t *testing.T) {
+	cfg := iconConfig{MaxBytes: defaultIconMaxBytes, Size: 64}
+
+	data, err := cfg.processIcon(testPNG(t, 200, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || conf.Width != 64 || conf.Height != 64 {
+		t.Errorf("got %s %dx%d, want png 64x64", format, conf.Width, conf.Height)
+	}
+}
+
+func TestProcessIconWebP(t *testing.T) {
+	// VP8L の 1x1 の WebP
+	webp := []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+	// WebP は書き出せないので PNG にする
+	data, err := iconConfig{MaxBytes: defaultIconMaxBytes, Size: 64}.processIcon(webp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || conf.Width != 64 || conf.Height != 64 {
+		t.Errorf("got %s %dx%d, want png 64x64", format, conf.Width, conf.Height)
+	}
+
+	// 縮小しなければそのまま
+	data, err = iconConfig{MaxBytes: defaultIconMaxBytes}.processIcon(webp)
+	if err != nil || !bytes.Equal(data, webp) {
+		t.Errorf("processIcon without resizing = %d bytes, %v, want the original", len(data), err)
+	}
+}
+
+func TestProcessIconRejectsUnsupportedFormat(t *testing.T) {
+	cfg := iconConfig{MaxBytes: defaultIconMaxBytes, Size: 64}
+
+	if _, err := cfg.processIcon([]byte("BM not an icon")); !errors.Is(err, errIconUnsupportedFormat) {
+		t.Errorf("processIcon(bmp) = %v, want %v", err, errIconUnsupportedFormat)
+	}
+}
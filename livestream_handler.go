@@ -13,18 +13,17 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 )
 
 type ReserveLivestreamRequest struct {
-	Tags         []int64 `json:"tags"`
-	Title        string  `json:"title"`
-	Description  string  `json:"description"`
-	PlaylistUrl  string  `json:"playlist_url"`
-	ThumbnailUrl string  `json:"thumbnail_url"`
-	StartAt      int64   `json:"start_at"`
-	EndAt        int64   `json:"end_at"`
+	Tags         []int64 `json:"tags" validate:"tags"`
+	Title        string  `json:"title" validate:"max=255,nocontrol"`
+	Description  string  `json:"description" validate:"maxbytes=65535"`
+	PlaylistUrl  string  `json:"playlist_url" validate:"required,max=255,url"`
+	ThumbnailUrl string  `json:"thumbnail_url" validate:"required,max=255,url"`
+	StartAt      int64   `json:"start_at" validate:"min=0"`
+	EndAt        int64   `json:"end_at" validate:"min=0"`
 }
 
 type LivestreamViewerModel struct {
@@ -73,20 +72,19 @@ func reserveLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	defer c.Request().Body.Close()
 
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
+	principal, err := currentUser(c)
+	if err != nil {
 		return err
 	}
-
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
+	userID := principal.ID
 
 	var req *ReserveLivestreamRequest
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -243,20 +241,17 @@ func searchLivestreamsHandler(c echo.Context) error {
 
 func getMyLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
-		return err
-	}
-
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
 	}
 	defer tx.Rollback()
 
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	userID := principal.ID
 
 	var livestreamModels []*LivestreamModel
 	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", userID); err != nil {
@@ -280,10 +275,6 @@ func getMyLivestreamsHandler(c echo.Context) error {
 
 func getUserLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
-		return err
-	}
-
 	username := c.Param("username")
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
@@ -324,15 +315,11 @@ func getUserLivestreamsHandler(c echo.Context) error {
 // viewerテーブルの廃止
 func enterLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
+	principal, err := currentUser(c)
+	if err != nil {
 		return err
 	}
-
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
+	userID := principal.ID
 
 	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
@@ -364,15 +351,11 @@ func enterLivestreamHandler(c echo.Context) error {
 
 func exitLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
+	principal, err := currentUser(c)
+	if err != nil {
 		return err
 	}
-
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
+	userID := principal.ID
 
 	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
@@ -399,10 +382,6 @@ func exitLivestreamHandler(c echo.Context) error {
 func getLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	if err := verifyUserSession(c); err != nil {
-		return err
-	}
-
 	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
@@ -438,10 +417,6 @@ func getLivestreamHandler(c echo.Context) error {
 func getLivecommentReportsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	if err := verifyUserSession(c); err != nil {
-		return err
-	}
-
 	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
@@ -459,10 +434,11 @@ func getLivecommentReportsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
 	}
 
-	// error already check
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already check
-	userID := sess.Values[defaultUserIDKey].(int64)
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	userID := principal.ID
 
 	if livestreamModel.UserID != userID {
 		return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livecomment reports")
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ログインの総当たり対策
+// ユーザ名ごと (設定すれば送信元IPごとにも) に失敗回数を数え、上限を超えたら失敗するたびに倍に延びる間ロックする
+// ロック中はパスワードを照合せずに 429 を返すので、bcrypt の計算も増えない
+
+const (
+	loginMaxUserFailuresEnvKey = "ISUCON13_LOGIN_MAX_USER_FAILURES"
+	loginMaxIPFailuresEnvKey   = "ISUCON13_LOGIN_MAX_IP_FAILURES"
+
+	defaultLoginMaxUserFailures = 5
+	// NAT やベンチマーカー、nginx の後ろでは多くのユーザが同じIPからログインするので、
+	// 送信元IPごとのロックは既定では使わない (ISUCON13_LOGIN_MAX_IP_FAILURES で有効にする)
+	defaultLoginMaxIPFailures = 0
+
+	// 上限を超えて最初のロックの長さ。以降は失敗するたびに倍にする
+	loginLockoutBase = 1 * time.Second
+	loginLockoutMax  = 15 * time.Minute
+	// 最後の失敗からこれだけ経てば失敗回数を忘れる
+	loginFailureWindow = 15 * time.Minute
+
+	loginThrottleSweepInterval = 1 * time.Minute
+)
+
+var loginThrottler *loginThrottle
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type loginThrottle struct {
+	// maxUserFailures, maxIPFailures はロックせずに許す失敗回数。0 なら数えない
+	maxUserFailures int
+	maxIPFailures   int
+
+	mu    sync.Mutex
+	users map[string]*loginFailures
+	ips   map[string]*loginFailures
+}
+
+type LoginLockout struct {
+	Key         string `json:"key"`
+	Failures    int    `json:"failures"`
+	LockedUntil int64  `json:"locked_until"`
+}
+
+func newLoginThrottleFromEnv() (*loginThrottle, error) {
+	maxUserFailures := defaultLoginMaxUserFailures
+	maxIPFailures := defaultLoginMaxIPFailures
+	for _, param := range []struct {
+		key string
+		dst *int
+	}{
+		{loginMaxUserFailuresEnvKey, &maxUserFailures},
+		{loginMaxIPFailuresEnvKey, &maxIPFailures},
+	} {
+		if v, ok := os.LookupEnv(param.key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("failed to parse environment variable '%s' as non-negative integer: %s", param.key, v)
+			}
+			*param.dst = n
+		}
+	}
+	return newLoginThrottle(maxUserFailures, maxIPFailures), nil
+}
+
+func newLoginThrottle(maxUserFailures int, maxIPFailures int) *loginThrottle {
+	return &loginThrottle{
+		maxUserFailures: maxUserFailures,
+		maxIPFailures:   maxIPFailures,
+		users:           map[string]*loginFailures{},
+		ips:             map[string]*loginFailures{},
+	}
+}
+
+// check はユーザ名か送信元IPがロックされていれば、ロックが解けるまでの時間を返す
+func (t *loginThrottle) check(username string, ip string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	if f, ok := t.users[username]; ok {
+		wait = max(wait, f.lockedUntil.Sub(now))
+	}
+	if f, ok := t.ips[ip]; ok {
+		wait = max(wait, f.lockedUntil.Sub(now))
+	}
+	return wait
+}
+
+// fail はログインの失敗を記録する
+func (t *loginThrottle) fail(username string, ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.maxUserFailures > 0 {
+		recordLoginFailure(t.users, username, t.maxUserFailures, now)
+	}
+	if t.maxIPFailures > 0 {
+		recordLoginFailure(t.ips, ip, t.maxIPFailures, now)
+	}
+}
+
+func recordLoginFailure(failures map[string]*loginFailures, key string, limit int, now time.Time) {
+	f, ok := failures[key]
+	if !ok || now.Sub(f.lastFailure) > loginFailureWindow {
+		f = &loginFailures{}
+		failures[key] = f
+	}
+	f.count++
+	f.lastFailure = now
+
+	if over := f.count - limit; over > 0 {
+		// 1s, 2s, 4s, ... と延ばし、loginLockoutMax で頭打ちにする
+		lockout := loginLockoutBase
+		for i := 1; i < over && lockout < loginLockoutMax; i++ {
+			lockout *= 2
+		}
+		f.lockedUntil = now.Add(min(lockout, loginLockoutMax))
+	}
+}
+
+// succeed はログインに成功したユーザの失敗回数を消し、送信元IPの失敗回数を半分にする
+// 同じIPの他のユーザがロックされないよう減らすが、自分のアカウントでログインして
+// 他のユーザへの総当たりを続けられないよう、すべては消さない
+func (t *loginThrottle) succeed(username string, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.users, username)
+	if f, ok := t.ips[ip]; ok {
+		f.count /= 2
+		if f.count == 0 {
+			delete(t.ips, ip)
+		}
+	}
+}
+
+// clear は管理用APIからロックを解除する。解除したかどうかを返す
+func (t *loginThrottle) clear(username string, ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found := false
+	if _, ok := t.users[username]; ok && username != "" {
+		delete(t.users, username)
+		found = true
+	}
+	if _, ok := t.ips[ip]; ok && ip != "" {
+		delete(t.ips, ip)
+		found = true
+	}
+	return found
+}
+
+// lockouts はロック中のユーザ名と送信元IP
+func (t *loginThrottle) lockouts(now time.Time) []LoginLockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := []LoginLockout{}
+	for prefix, failures := range map[string]map[string]*loginFailures{"user:": t.users, "ip:": t.ips} {
+		for key, f := range failures {
+			if f.lockedUntil.After(now) {
+				res = append(res, LoginLockout{Key: prefix + key, Failures: f.count, LockedUntil: f.lockedUntil.Unix()})
+			}
+		}
+	}
+	return res
+}
+
+func (t *loginThrottle) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.users = map[string]*loginFailures{}
+	t.ips = map[string]*loginFailures{}
+}
+
+// sweepPeriodically は失敗回数を忘れてよい記録を消す
+func (t *loginThrottle) sweepPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(loginThrottleSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.mu.Lock()
+			for _, failures := range []map[string]*loginFailures{t.users, t.ips} {
+				for key, f := range failures {
+					if now.Sub(f.lastFailure) > loginFailureWindow && now.After(f.lockedUntil) {
+						delete(failures, key)
+					}
+				}
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// loginLockedError はロック中のエラー応答
+func loginLockedError(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed login attempts")
+}
+
+// ログインのロック一覧API
+// GET /api/login/lockouts
+func getLoginLockoutsHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, loginThrottler.lockouts(time.Now()))
+}
+
+// ログインのロック解除API
+// DELETE /api/login/lockouts?username=...&ip=...
+func deleteLoginLockoutHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	username := c.QueryParam("username")
+	ip := c.QueryParam("ip")
+	if username == "" && ip == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "username or ip is required")
+	}
+	if ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "ip must be an IP address")
+		}
+		ip = parsed.String()
+	}
+
+	if !loginThrottler.clear(username, ip) {
+		return echo.NewHTTPError(http.StatusNotFound, "lockout not found")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleIPLockoutIsOptIn(t *testing.T) {
+	throttle := newLoginThrottle(defaultLoginMaxUserFailures, defaultLoginMaxIPFailures)
+	now := time.Now()
+
+	// NAT の後ろの多くのユーザが同じIPから間違えても、他のユーザはロックされない
+	for i := 0; i < 1000; i++ {
+		throttle.fail(fmt.Sprintf("user%d", i), "192.0.2.1", now)
+	}
+	if wait := throttle.check("alice", "192.0.2.1", now); wait != 0 {
+		t.Errorf("alice is locked for %v by failures of other users", wait)
+	}
+
+	// ユーザ名ごとのロックは既定で有効
+	for i := 0; i <= defaultLoginMaxUserFailures; i++ {
+		throttle.fail("bob", "192.0.2.1", now)
+	}
+	if wait := throttle.check("bob", "192.0.2.2", now); wait == 0 {
+		t.Error("bob is not locked")
+	}
+}
+
+func TestLoginThrottleSuccessDecaysIPFailures(t *testing.T) {
+	const maxIPFailures = 10
+	throttle := newLoginThrottle(defaultLoginMaxUserFailures, maxIPFailures)
+	now := time.Now()
+
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			throttle.fail(fmt.Sprintf("user%d", i), "192.0.2.1", now)
+		}
+	}
+	locked := func() bool {
+		return throttle.check("alice", "192.0.2.1", now) > 0
+	}
+
+	fail(maxIPFailures)
+	if locked() {
+		t.Fatal("locked before exceeding the limit")
+	}
+
+	// 成功すると送信元IPの失敗回数は半分になる
+	throttle.succeed("alice", "192.0.2.1")
+	fail(maxIPFailures / 2)
+	if locked() {
+		t.Fatal("locked although a login from the ip succeeded")
+	}
+	// すべては消えないので、失敗を続ければロックされる
+	fail(1)
+	if !locked() {
+		t.Fatal("not locked after exceeding the decayed limit")
+	}
+
+	// 失敗回数が 0 になれば記録を消す
+	throttle.clear("", "192.0.2.1")
+	fail(1)
+	throttle.succeed("alice", "192.0.2.1")
+	if _, ok := throttle.ips["192.0.2.1"]; ok {
+		t.Error("ip failures are kept after decaying to 0")
+	}
+}
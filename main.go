@@ -4,13 +4,20 @@ package main
 // sqlx的な参考: https://jmoiron.github.io/sqlx/
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/goccy/go-json"
 
@@ -28,13 +35,22 @@ const (
 	listenPort                     = 8080
 	powerDNSSubdomainAddressEnvKey = "ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS"
 	iconDir                        = "/home/isucon/webapp/go/icon"
+	adminTokenHeader               = "X-Admin-Token"
+
+	// ISUCON13_ENV=production ではデフォルトのセッション鍵では起動しない
+	appEnvEnvKey        = "ISUCON13_ENV"
+	appEnvProduction    = "production"
+	sessionSecretEnvKey = "ISUCON13_SESSION_SECRETKEY"
+	// 鍵を入れ替えた後も、以前の鍵で署名されたクッキーを読めるようにする (カンマ区切り)
+	sessionPreviousSecretsEnvKey = "ISUCON13_SESSION_PREVIOUS_SECRETKEYS"
+	defaultSessionSecret         = "isucon13_session_cookiestore_defaultsecret"
 )
 
 var (
 	powerDNSSubdomainAddress string
 	dbConn                   *sqlx.DB
-	secret                   = []byte("isucon13_session_cookiestore_defaultsecret")
-	bcryptAPI                = fmt.Sprintf("http://192.168.0.13:%d/api/bcrypt", listenPort)
+	// 管理用APIのトークン。未設定の場合は管理用APIを使えない
+	adminToken []byte
 )
 
 func init() {
@@ -43,9 +59,33 @@ func init() {
 	}
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	if secretKey, ok := os.LookupEnv("ISUCON13_SESSION_SECRETKEY"); ok {
-		secret = []byte(secretKey)
+	if token, ok := os.LookupEnv("ISUCON13_ADMIN_TOKEN"); ok {
+		adminToken = []byte(token)
 	}
+	if secret, ok := os.LookupEnv(bcryptSecretEnvKey); ok {
+		bcryptSecret = []byte(secret)
+	}
+}
+
+// sessionKeyPairsFromEnv はクッキーの署名鍵を securecookie の (hashKey, blockKey) の組で返す
+// 新しいクッキーは先頭の最新の鍵で署名し、以前の鍵で署名されたクッキーも読める
+func sessionKeyPairsFromEnv() ([][]byte, error) {
+	current := os.Getenv(sessionSecretEnvKey)
+	if current == "" || current == defaultSessionSecret {
+		if os.Getenv(appEnvEnvKey) == appEnvProduction {
+			return nil, fmt.Errorf("environ %s must be provided in production", sessionSecretEnvKey)
+		}
+		log.Printf("environ %s is not set. the default session secret is used\n", sessionSecretEnvKey)
+		current = defaultSessionSecret
+	}
+
+	keyPairs := [][]byte{[]byte(current), nil}
+	for _, key := range strings.Split(os.Getenv(sessionPreviousSecretsEnvKey), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keyPairs = append(keyPairs, []byte(key), nil)
+		}
+	}
+	return keyPairs, nil
 }
 
 type InitializeResponse struct {
@@ -125,7 +165,13 @@ func initializeHandler(c echo.Context) error {
 	userNameCache = Map[string, cachedUser]{}
 	livestreamTagsCache = Map[int64, []int64]{}
 	livestreamCache = Map[int64, LivestreamModel]{}
-	initDNSRecordMap()
+	loginThrottler.reset()
+	if err := sessionStore.Reset(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset sessions: "+err.Error())
+	}
+	if err := dnsStore.Reset(dnsZone.DefaultRecords()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset dns records: "+err.Error())
+	}
 
 	os.RemoveAll(iconDir)
 	os.MkdirAll(iconDir, 0755)
@@ -149,73 +195,93 @@ func (j *JSONSerializer) Deserialize(c echo.Context, i interface{}) error {
 }
 
 func main() {
-	hostName, _ := os.Hostname()
-
-	if hostName == "node3" {
-		exec.Command("bash", "-c", "iptables -t nat -A PREROUTING -p udp --dport 53 -j DNAT --to 192.168.0.11:53").CombinedOutput()
-		exec.Command("bash", "-c", "iptables -t nat -A POSTROUTING -p udp -d 192.168.0.11 --dport 53 -j MASQUERADE").CombinedOutput()
-	}
-
 	e := echo.New()
 	e.Debug = false
 	e.Logger.SetLevel(echolog.WARN)
 	// e.Use(middleware.Logger())
-	cookieStore := sessions.NewCookieStore(secret)
-	cookieStore.Options.Domain = "*.u.isucon.dev"
+	sessionKeyPairs, err := sessionKeyPairsFromEnv()
+	if err != nil {
+		e.Logger.Errorf("failed to configure session secret: %v", err)
+		os.Exit(1)
+	}
+	sessionCfg, err = sessionConfigFromEnv()
+	if err != nil {
+		e.Logger.Errorf("failed to configure session cookie: %v", err)
+		os.Exit(1)
+	}
+	iconCfg, err = iconConfigFromEnv()
+	if err != nil {
+		e.Logger.Errorf("failed to configure icon: %v", err)
+		os.Exit(1)
+	}
+	cookieStore := sessions.NewCookieStore(sessionKeyPairs...)
+	cookieStore.Options = sessionCfg.options(sessionCfg.maxAge())
 	e.Use(session.Middleware(cookieStore))
 	// e.Use(middleware.Recover())
 	e.JSONSerializer = &JSONSerializer{}
+	// nginx を経由したリクエストの送信元IPを X-Forwarded-For から取る (信頼するのはローカルと内部ネットワークのプロキシだけ)
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
 
 	// 初期化
 	e.POST("/api/initialize", initializeHandler)
 
+	// ログインが必要なAPIはルートごとに requireLogin を付ける
+	// ハンドラは currentUser でログイン中のユーザを取り出す
+	// (/api のグループに付けると、存在しないパスも 404 ではなく 401 になる)
+
 	// top
 	e.GET("/api/tag", getTagHandler)
-	e.GET("/api/user/:username/theme", getStreamerThemeHandler)
+	e.GET("/api/user/:username/theme", getStreamerThemeHandler, requireLogin)
 
 	// livestream
 	// reserve livestream
-	e.POST("/api/livestream/reservation", reserveLivestreamHandler)
+	e.POST("/api/livestream/reservation", reserveLivestreamHandler, requireLogin)
 	// list livestream
 	e.GET("/api/livestream/search", searchLivestreamsHandler)
-	e.GET("/api/livestream", getMyLivestreamsHandler)
-	e.GET("/api/user/:username/livestream", getUserLivestreamsHandler)
+	e.GET("/api/livestream", getMyLivestreamsHandler, requireLogin)
+	e.GET("/api/user/:username/livestream", getUserLivestreamsHandler, requireLogin)
 	// get livestream
-	e.GET("/api/livestream/:livestream_id", getLivestreamHandler)
+	e.GET("/api/livestream/:livestream_id", getLivestreamHandler, requireLogin)
 	// get polling livecomment timeline
-	e.GET("/api/livestream/:livestream_id/livecomment", getLivecommentsHandler)
+	e.GET("/api/livestream/:livestream_id/livecomment", getLivecommentsHandler, requireLogin)
 	// ライブコメント投稿
-	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler)
-	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler)
-	e.GET("/api/livestream/:livestream_id/reaction", getReactionsHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler, requireLogin)
+	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler, requireLogin)
+	e.GET("/api/livestream/:livestream_id/reaction", getReactionsHandler, requireLogin)
 
 	// (配信者向け)ライブコメントの報告一覧取得API
-	e.GET("/api/livestream/:livestream_id/report", getLivecommentReportsHandler)
-	e.GET("/api/livestream/:livestream_id/ngwords", getNgwords)
+	e.GET("/api/livestream/:livestream_id/report", getLivecommentReportsHandler, requireLogin)
+	e.GET("/api/livestream/:livestream_id/ngwords", getNgwords, requireLogin)
 	// ライブコメント報告
-	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler, requireLogin)
 	// 配信者によるモデレーション (NGワード登録)
-	e.POST("/api/livestream/:livestream_id/moderate", moderateHandler)
+	e.POST("/api/livestream/:livestream_id/moderate", moderateHandler, requireLogin)
 
 	// livestream_viewersにINSERTするため必要
 	// ユーザ視聴開始 (viewer)
-	e.POST("/api/livestream/:livestream_id/enter", enterLivestreamHandler)
+	e.POST("/api/livestream/:livestream_id/enter", enterLivestreamHandler, requireLogin)
 	// ユーザ視聴終了 (viewer)
-	e.DELETE("/api/livestream/:livestream_id/exit", exitLivestreamHandler)
+	e.DELETE("/api/livestream/:livestream_id/exit", exitLivestreamHandler, requireLogin)
 
 	// user
 	e.POST("/api/register", registerHandler)
 	e.POST("/api/login", loginHandler)
-	e.GET("/api/user/me", getMeHandler)
+	e.POST("/api/logout", logoutHandler, requireLogin)
+	e.GET("/api/user/me", getMeHandler, requireLogin)
+	e.PATCH("/api/user/me", patchMeHandler, requireLogin)
+	e.DELETE("/api/user/me", deleteMeHandler, requireLogin)
+	e.PUT("/api/user/me/theme", putMyThemeHandler, requireLogin)
+	e.GET("/api/user/me/sessions", getMySessionsHandler, requireLogin)
+	e.DELETE("/api/user/me/sessions/:id", deleteMySessionHandler, requireLogin)
 	// フロントエンドで、配信予約のコラボレーターを指定する際に必要
-	e.GET("/api/user/:username", getUserHandler)
-	e.GET("/api/user/:username/statistics", getUserStatisticsHandler)
+	e.GET("/api/user/:username", getUserHandler, requireLogin)
+	e.GET("/api/user/:username/statistics", getUserStatisticsHandler, requireLogin)
 	e.GET("/api/user/:username/icon", getIconHandler)
-	e.POST("/api/icon", postIconHandler)
+	e.POST("/api/icon", postIconHandler, requireLogin)
 
 	// stats
 	// ライブ配信統計情報
-	e.GET("/api/livestream/:livestream_id/statistics", getLivestreamStatisticsHandler)
+	e.GET("/api/livestream/:livestream_id/statistics", getLivestreamStatisticsHandler, requireLogin)
 
 	// 課金情報
 	e.GET("/api/payment", GetPaymentResult)
@@ -223,6 +289,21 @@ func main() {
 	// bcrypt計算
 	e.POST("/api/bcrypt/compair", bcryptCompairHandler)
 	e.POST("/api/bcrypt/sum", bcryptSumHandler)
+	e.GET("/api/bcrypt/stats", getBcryptStatsHandler)
+
+	// ログインのロック
+	e.GET("/api/login/lockouts", getLoginLockoutsHandler)
+	e.DELETE("/api/login/lockouts", deleteLoginLockoutHandler)
+
+	// DNS
+	e.GET("/api/dns/metrics", getDNSMetricsHandler)
+	e.GET("/api/dns/records", getDNSRecordsHandler)
+	e.PUT("/api/dns/records/:name", putDNSRecordHandler)
+	e.DELETE("/api/dns/records/:name", deleteDNSRecordHandler)
+
+	// 予約済みユーザ名
+	e.GET("/api/reserved_names", getReservedNamesHandler)
+	e.PUT("/api/reserved_names/:name", putReservedNameHandler)
 
 	e.HTTPErrorHandler = errorResponseHandler
 
@@ -235,6 +316,33 @@ func main() {
 	defer conn.Close()
 	dbConn = conn
 
+	bcryptWorkerPool, err = newBcryptPoolFromEnv()
+	if err != nil {
+		e.Logger.Errorf("failed to configure bcrypt workers: %v", err)
+		os.Exit(1)
+	}
+	bcryptAPIClient, err = newBcryptClientFromEnv(bcryptWorkerPool)
+	if err != nil {
+		e.Logger.Errorf("failed to configure bcrypt api client: %v", err)
+		os.Exit(1)
+	}
+	passwordHasher, err = newPasswordPolicyFromEnv(bcryptAPIClient, bcryptWorkerPool)
+	if err != nil {
+		e.Logger.Errorf("failed to configure password hashing: %v", err)
+		os.Exit(1)
+	}
+
+	sessionStore, err = newSessionStoreFromEnv(dbConn)
+	if err != nil {
+		e.Logger.Errorf("failed to configure session store: %v", err)
+		os.Exit(1)
+	}
+	loginThrottler, err = newLoginThrottleFromEnv()
+	if err != nil {
+		e.Logger.Errorf("failed to configure login throttling: %v", err)
+		os.Exit(1)
+	}
+
 	subdomainAddr, ok := os.LookupEnv(powerDNSSubdomainAddressEnvKey)
 	if !ok {
 		e.Logger.Errorf("environ %s must be provided", powerDNSSubdomainAddressEnvKey)
@@ -242,20 +350,71 @@ func main() {
 	}
 	powerDNSSubdomainAddress = subdomainAddr
 
+	// DNSサーバ起動
+	stopDNSServer, err := startDNSServer()
+	if err != nil {
+		e.Logger.Errorf("failed to start DNS server: %v", err)
+		os.Exit(1)
+	}
+	// ゾーンファイルの名前をユーザ名として登録させない
+	reservedNames, err = newReservedNamesFromEnv(dnsZone)
+	if err != nil {
+		e.Logger.Errorf("failed to configure reserved names: %v", err)
+		os.Exit(1)
+	}
+
 	// HTTPサーバ起動
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go sweepSessionsPeriodically(ctx, sessionStore)
+	go loginThrottler.sweepPeriodically(ctx)
+
 	listenAddr := net.JoinHostPort("", strconv.Itoa(listenPort))
-	if err := e.Start(listenAddr); err != nil {
-		e.Logger.Errorf("failed to start HTTP server: %v", err)
-		os.Exit(1)
+	go func() {
+		if err := e.Start(listenAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Errorf("failed to start HTTP server: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+
+	// 終了時にレコードのスナップショットを書き出す
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Errorf("failed to shutdown HTTP server: %v", err)
 	}
+	if err := stopDNSServer(shutdownCtx); err != nil {
+		e.Logger.Errorf("failed to shutdown DNS server: %v", err)
+	}
+}
+
+// verifyAdmin は管理用APIのトークンを検証する
+func verifyAdmin(c echo.Context) error {
+	token := c.Request().Header.Get(adminTokenHeader)
+	if len(adminToken) == 0 || subtle.ConstantTimeCompare([]byte(token), adminToken) != 1 {
+		return echo.NewHTTPError(http.StatusForbidden, "admin token is required")
+	}
+	return nil
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// Fields はリクエストの検証に失敗したフィールド
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 func errorResponseHandler(err error, c echo.Context) {
 	c.Logger().Errorf("error at %s: %+v", c.Path(), err)
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		if e := c.JSON(http.StatusBadRequest, &ErrorResponse{Error: err.Error(), Fields: ve.Fields}); e != nil {
+			c.Logger().Errorf("%+v", e)
+		}
+		return
+	}
 	if he, ok := err.(*echo.HTTPError); ok {
 		if e := c.JSON(he.Code, &ErrorResponse{Error: err.Error()}); e != nil {
 			c.Logger().Errorf("%+v", e)
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
+)
+
+// パスワードのハッシュ方式
+// ハッシュ文字列にアルゴリズムとパラメータを含めるので、方式を変えても以前のハッシュで照合できる
+// ログイン時に現在の方式と違うハッシュだった場合は、新しい方式で計算し直して保存する
+// 計算し直しはリクエストと CPU を取り合うので、ISUCON13_PASSWORD_REHASH_RATE で1秒あたりの回数を指定した場合だけ行う
+
+const (
+	passwordHashEnvKey  = "ISUCON13_PASSWORD_HASH"
+	bcryptCostEnvKey    = "ISUCON13_BCRYPT_COST"
+	argon2TimeEnvKey    = "ISUCON13_ARGON2_TIME"
+	argon2MemoryEnvKey  = "ISUCON13_ARGON2_MEMORY"
+	argon2ThreadsEnvKey = "ISUCON13_ARGON2_THREADS"
+	rehashRateEnvKey    = "ISUCON13_PASSWORD_REHASH_RATE"
+
+	passwordHashBcrypt   = "bcrypt"
+	passwordHashArgon2id = "argon2id"
+
+	// OWASP Password Storage Cheat Sheet の推奨値 (m=19MiB, t=2, p=1)
+	defaultArgon2Time    = 2
+	defaultArgon2Memory  = 19 * 1024
+	defaultArgon2Threads = 1
+	argon2KeyLen         = 32
+	argon2SaltLen        = 16
+
+	passwordRehashTimeout = 10 * time.Second
+)
+
+var errPasswordMismatch = errors.New("password mismatch")
+
+var passwordHasher *passwordPolicy
+
+type PasswordHasher interface {
+	// Hash はアルゴリズムとパラメータを含むハッシュ文字列を返す
+	Hash(ctx context.Context, password string) (string, error)
+	// Verify は一致しなければ errPasswordMismatch を返す
+	Verify(ctx context.Context, hashedPassword string, password string) error
+	// Identify は hashedPassword がこの方式のハッシュかどうか
+	Identify(hashedPassword string) bool
+	// NeedsRehash は hashedPassword のパラメータが現在の設定と違うかどうか
+	NeedsRehash(hashedPassword string) bool
+}
+
+// passwordPolicy は新しいハッシュを current で計算し、照合は対応するどの方式でも行う
+type passwordPolicy struct {
+	current PasswordHasher
+	hashers []PasswordHasher
+	// rehash はログイン時に計算し直す回数の制限。nil なら計算し直さない
+	rehash *rate.Limiter
+}
+
+func newPasswordPolicyFromEnv(client *bcryptClient, pool *bcryptPool) (*passwordPolicy, error) {
+	cost := bcrypt.DefaultCost
+	if v, ok := os.LookupEnv(bcryptCostEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as bcrypt cost: %s", bcryptCostEnvKey, v)
+		}
+		cost = n
+	}
+	bh := &bcryptHasher{cost: cost, client: client}
+
+	ah := &argon2idHasher{time: defaultArgon2Time, memory: defaultArgon2Memory, threads: defaultArgon2Threads, pool: pool}
+	for _, param := range []struct {
+		key string
+		dst *uint32
+	}{
+		{argon2TimeEnvKey, &ah.time},
+		{argon2MemoryEnvKey, &ah.memory},
+	} {
+		if v, ok := os.LookupEnv(param.key); ok {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", param.key, v)
+			}
+			*param.dst = uint32(n)
+		}
+	}
+	if v, ok := os.LookupEnv(argon2ThreadsEnvKey); ok {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as positive integer: %s", argon2ThreadsEnvKey, v)
+		}
+		ah.threads = uint8(n)
+	}
+
+	policy := &passwordPolicy{hashers: []PasswordHasher{bh, ah}}
+	if v, ok := os.LookupEnv(rehashRateEnvKey); ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("failed to parse environment variable '%s' as non-negative number: %s", rehashRateEnvKey, v)
+		}
+		if r > 0 {
+			policy.rehash = rate.NewLimiter(rate.Limit(r), 1)
+		}
+	}
+	switch v := os.Getenv(passwordHashEnvKey); v {
+	case "", passwordHashBcrypt:
+		policy.current = bh
+	case passwordHashArgon2id:
+		policy.current = ah
+	default:
+		return nil, fmt.Errorf("unknown password hash '%s' in environment variable '%s'", v, passwordHashEnvKey)
+	}
+	return policy, nil
+}
+
+func (p *passwordPolicy) Hash(ctx context.Context, password string) (string, error) {
+	return p.current.Hash(ctx, password)
+}
+
+func (p *passwordPolicy) Verify(ctx context.Context, hashedPassword string, password string) error {
+	for _, h := range p.hashers {
+		if h.Identify(hashedPassword) {
+			return h.Verify(ctx, hashedPassword, password)
+		}
+	}
+	return errors.New("unknown password hash format")
+}
+
+func (p *passwordPolicy) NeedsRehash(hashedPassword string) bool {
+	return !p.current.Identify(hashedPassword) || p.current.NeedsRehash(hashedPassword)
+}
+
+// allowRehash はログインに成功したユーザのハッシュを今計算し直すかどうか
+// 制限を超えた分は計算し直さず、次のログインに回す
+func (p *passwordPolicy) allowRehash(hashedPassword string) bool {
+	return p.rehash != nil && p.NeedsRehash(hashedPassword) && p.rehash.Allow()
+}
+
+// rehashPassword はログインに成功したユーザのハッシュを現在の方式で計算し直す
+// 同時にパスワードが変更されていた場合は上書きしない
+func rehashPassword(userID int64, oldHashedPassword string, password string) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordRehashTimeout)
+	defer cancel()
+
+	hashedPassword, err := passwordHasher.Hash(ctx, password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %d: %+v\n", userID, err)
+		return
+	}
+	if _, err := dbConn.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?", hashedPassword, userID, oldHashedPassword); err != nil {
+		log.Printf("failed to update password of user %d: %+v\n", userID, err)
+	}
+}
+
+// bcryptHasher は /api/bcrypt に計算を任せる (使えなければ自分で計算する)
+type bcryptHasher struct {
+	cost   int
+	client *bcryptClient
+}
+
+func (h *bcryptHasher) Hash(ctx context.Context, password string) (string, error) {
+	return h.client.Sum(ctx, password, h.cost)
+}
+
+func (h *bcryptHasher) Verify(ctx context.Context, hashedPassword string, password string) error {
+	err := h.client.Compare(ctx, hashedPassword, password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return errPasswordMismatch
+	}
+	return err
+}
+
+func (h *bcryptHasher) Identify(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, "$2a$") ||
+		strings.HasPrefix(hashedPassword, "$2b$") ||
+		strings.HasPrefix(hashedPassword, "$2y$")
+}
+
+func (h *bcryptHasher) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != h.cost
+}
+
+// argon2idHasher はハッシュを PHC 文字列形式 ($argon2id$v=19$m=...,t=...,p=...$salt$hash) で表す
+// 計算は bcrypt と同じワーカーで行う
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	pool    *bcryptPool
+}
+
+type argon2idParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(hashedPassword string) (argon2idParams, error) {
+	var p argon2idParams
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != passwordHashArgon2id {
+		return p, errors.New("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, fmt.Errorf("unsupported argon2id version: %s", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, fmt.Errorf("invalid argon2id parameters: %s", parts[3])
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func (h *argon2idHasher) Hash(ctx context.Context, password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var key []byte
+	if err := h.pool.Do(ctx, func() {
+		key = argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2KeyLen)
+	}); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		passwordHashArgon2id, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *argon2idHasher) Verify(ctx context.Context, hashedPassword string, password string) error {
+	p, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	if err := h.pool.Do(ctx, func() {
+		key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	}); err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(key, p.key) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+func (h *argon2idHasher) Identify(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, "$"+passwordHashArgon2id+"$")
+}
+
+func (h *argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	p, err := parseArgon2id(hashedPassword)
+	return err != nil || p.time != h.time || p.memory != h.memory || p.threads != h.threads || len(p.key) != argon2KeyLen
+}
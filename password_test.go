@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
+)
+
+func TestPasswordPolicyAllowRehash(t *testing.T) {
+	seed, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := &bcryptHasher{cost: bcrypt.MinCost + 1}
+
+	// 既定では計算し直さない
+	disabled := &passwordPolicy{current: hasher, hashers: []PasswordHasher{hasher}}
+	if disabled.allowRehash(string(seed)) {
+		t.Error("rehash is allowed without a rate")
+	}
+
+	policy := &passwordPolicy{current: hasher, hashers: []PasswordHasher{hasher}, rehash: rate.NewLimiter(rate.Limit(0.001), 1)}
+	if policy.allowRehash(string(current)) {
+		t.Error("rehash is allowed for a current hash")
+	}
+	if !policy.allowRehash(string(seed)) {
+		t.Error("rehash is not allowed for an old hash")
+	}
+	// 制限を超えた分は次のログインに回す
+	if policy.allowRehash(string(seed)) {
+		t.Error("rehash is allowed beyond the rate")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	dnsserver "github.com/isucon/isucon13/webapp/go/dns"
+	"github.com/labstack/echo/v4"
+)
+
+// ユーザ名の予約
+// ユーザ名はそのまま <name>.u.isucon.dev のサブドメインになるので、ゾーンファイルにある名前 (www, mail, ns1 など) を登録させない
+// ゾーンファイルの名前に加えて、環境変数と管理用APIで名前を足せる
+
+const reservedNamesEnvKey = "ISUCON13_RESERVED_NAMES"
+
+const (
+	reservedNameSourceDefault = "default"
+	reservedNameSourceZone    = "zone"
+	reservedNameSourceConfig  = "config"
+	reservedNameSourceAdmin   = "admin"
+)
+
+// ゾーンファイルに関係なく予約する名前
+var defaultReservedNames = []string{"pipe"}
+
+var reservedNames *reservedNameRegistry
+
+type ReservedName struct {
+	Name string `json:"name"`
+	// Source は予約した理由 (default, zone, config, admin)
+	Source string `json:"source"`
+}
+
+type reservedNameRegistry struct {
+	mu sync.RWMutex
+	// キーは小文字の名前、値は Source
+	names map[string]string
+}
+
+// newReservedNamesFromEnv はゾーンファイルのサブドメインと ISUCON13_RESERVED_NAMES (カンマ区切り) を予約する
+// ゾーンファイルには初期データのユーザ名も含まれるが、それらは登録済みなので予約しても変わらない
+func newReservedNamesFromEnv(zone *dnsserver.Zone) (*reservedNameRegistry, error) {
+	r := &reservedNameRegistry{names: map[string]string{}}
+	for _, name := range defaultReservedNames {
+		r.add(name, reservedNameSourceDefault)
+	}
+	for _, label := range zone.Labels() {
+		// ゾーンの頂点 (u.isucon.dev 自体) はユーザ名と重ならない
+		if label != "" {
+			r.add(label, reservedNameSourceZone)
+		}
+	}
+
+	for _, name := range strings.Split(os.Getenv(reservedNamesEnvKey), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !dnsLabelRegexp.MatchString(name) {
+			return nil, fmt.Errorf("failed to parse environment variable '%s': invalid name '%s'", reservedNamesEnvKey, name)
+		}
+		r.add(name, reservedNameSourceConfig)
+	}
+	return r, nil
+}
+
+// isReserved は大文字小文字を区別せずに name が予約されているか調べる
+func (r *reservedNameRegistry) isReserved(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.names[strings.ToLower(name)]
+	return ok
+}
+
+// add は name を予約し、予約の Source を返す
+// 既に予約されていた場合は元の Source のまま変えない
+func (r *reservedNameRegistry) add(name string, source string) ReservedName {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name = strings.ToLower(name)
+	if current, ok := r.names[name]; ok {
+		return ReservedName{Name: name, Source: current}
+	}
+	r.names[name] = source
+	return ReservedName{Name: name, Source: source}
+}
+
+func (r *reservedNameRegistry) list() []ReservedName {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]ReservedName, 0, len(r.names))
+	for name, source := range r.names {
+		res = append(res, ReservedName{Name: name, Source: source})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// 予約済みユーザ名一覧API
+// GET /api/reserved_names
+func getReservedNamesHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, reservedNames.list())
+}
+
+// ユーザ名予約API
+// PUT /api/reserved_names/:name
+// 管理用APIで足した名前はプロセス内だけで保持し、initialize では消さない
+func putReservedNameHandler(c echo.Context) error {
+	if err := verifyAdmin(c); err != nil {
+		return err
+	}
+
+	name := strings.ToLower(c.Param("name"))
+	if !dnsLabelRegexp.MatchString(name) {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must consist of lowercase letters, digits and hyphens, and must not start or end with a hyphen")
+	}
+
+	return c.JSON(http.StatusOK, reservedNames.add(name, reservedNameSourceAdmin))
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/sessions"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// サーバ側のセッション
+// クッキーの SESSIONID をキーに保存し、ログアウトや失効させたセッションを使えなくする
+// 既定では複数台で共有できるよう MySQL の sessions テーブルに持つ
+// ISUCON13_SESSION_STORE=memory ならメモリに持つが、そのセッションはログインしたノードでしか使えない
+
+const (
+	sessionStoreEnvKey = "ISUCON13_SESSION_STORE"
+
+	sessionStoreMemory = "memory"
+	sessionStoreMySQL  = "mysql"
+
+	// 期限切れのセッションを消す間隔
+	sessionSweepInterval = 1 * time.Minute
+
+	sessionCookieDomainEnvKey = "ISUCON13_SESSION_COOKIE_DOMAIN"
+	sessionCookieSecureEnvKey = "ISUCON13_SESSION_COOKIE_SECURE"
+	sessionLifetimeEnvKey     = "ISUCON13_SESSION_LIFETIME"
+
+	// sessions.user_agent の長さ
+	sessionUserAgentMaxBytes = 255
+)
+
+var sessionStore SessionStore
+
+// sessionConfig はセッションのクッキーの属性と有効期間
+// ログイン、延長、ログアウトのどれも同じ属性でクッキーを書く
+type sessionConfig struct {
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	// Lifetime はログインや最後の延長からの有効期間
+	// 残りが半分を切ったリクエストで期限を延ばす
+	Lifetime time.Duration
+}
+
+var sessionCfg = sessionConfig{
+	// サブドメイン (<username>.u.isucon.dev) からも送られるよう、ドメインを指定する
+	Domain:   "u.isucon.dev",
+	Path:     "/",
+	Secure:   true,
+	HttpOnly: true,
+	SameSite: http.SameSiteLaxMode,
+	Lifetime: 1 * time.Hour,
+}
+
+func sessionConfigFromEnv() (sessionConfig, error) {
+	cfg := sessionCfg
+	if v, ok := os.LookupEnv(sessionCookieDomainEnvKey); ok {
+		cfg.Domain = v
+	}
+	if v, ok := os.LookupEnv(sessionCookieSecureEnvKey); ok {
+		secure, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as bool: %+v", sessionCookieSecureEnvKey, err)
+		}
+		cfg.Secure = secure
+	}
+	if v, ok := os.LookupEnv(sessionLifetimeEnvKey); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return cfg, fmt.Errorf("failed to parse environment variable '%s' as duration of at least 1s: %s", sessionLifetimeEnvKey, v)
+		}
+		cfg.Lifetime = d
+	}
+	return cfg, nil
+}
+
+// options はクッキーの属性。maxAge が負ならクッキーを消す
+func (cfg sessionConfig) options(maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Domain:   cfg.Domain,
+		Path:     cfg.Path,
+		MaxAge:   maxAge,
+		Secure:   cfg.Secure,
+		HttpOnly: cfg.HttpOnly,
+		SameSite: cfg.SameSite,
+	}
+}
+
+func (cfg sessionConfig) maxAge() int {
+	return int(cfg.Lifetime / time.Second)
+}
+
+// sessionExpired は期限 expiresAt のセッションが now の時点で切れているかどうか
+// 期限ちょうどはまだ使える (ListByUser や DeleteExpired と揃える)
+func sessionExpired(expiresAt int64, now time.Time) bool {
+	return now.Unix() > expiresAt
+}
+
+// needsRenewal は期限 expiresAt のセッションを now の時点で延ばすかどうか
+// 毎回クッキーを書き直さないよう、残りが有効期間の半分を切ってから延ばす
+func (cfg sessionConfig) needsRenewal(expiresAt int64, now time.Time) bool {
+	return time.Unix(expiresAt, 0).Sub(now) < cfg.Lifetime/2
+}
+
+// renewSession はセッションの期限を now から Lifetime 後に延ばし、クッキーを書き直す
+func renewSession(c echo.Context, sess *sessions.Session, sessionID string, now time.Time) error {
+	expiresAt := now.Add(sessionCfg.Lifetime).Unix()
+	if err := sessionStore.Extend(c.Request().Context(), sessionID, expiresAt); err != nil {
+		return err
+	}
+
+	sess.Options = sessionCfg.options(sessionCfg.maxAge())
+	sess.Values[defaultSessionExpiresKey] = expiresAt
+	return sess.Save(c.Request(), c.Response())
+}
+
+type Session struct {
+	ID        string `json:"id" db:"id"`
+	UserID    int64  `json:"user_id" db:"user_id"`
+	UserAgent string `json:"user_agent" db:"user_agent"`
+	IPAddress string `json:"ip_address" db:"ip_address"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+	ExpiresAt int64  `json:"expires_at" db:"expires_at"`
+}
+
+// truncateUTF8 は s を n バイト以下に切り詰める
+// 文字の途中で切らないよう、UTF-8 の文字の先頭まで戻る
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+type SessionResponse struct {
+	Session
+	// Current はこのリクエストのセッションかどうか
+	Current bool `json:"current"`
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, s Session) error
+	// Get はセッションがない場合に sql.ErrNoRows を返す
+	Get(ctx context.Context, id string) (Session, error)
+	Delete(ctx context.Context, id string) error
+	// Extend はセッションの期限を expiresAt に変える
+	Extend(ctx context.Context, id string, expiresAt int64) error
+	// DeleteByUser はユーザのセッションをすべて消す
+	DeleteByUser(ctx context.Context, userID int64) error
+	// ListByUser は期限内のセッションを作成日時の新しい順に返す
+	ListByUser(ctx context.Context, userID int64) ([]Session, error)
+	// DeleteExpired は now までに期限が切れたセッションを消す
+	DeleteExpired(ctx context.Context, now time.Time) error
+	Reset(ctx context.Context) error
+}
+
+func newSessionStoreFromEnv(db *sqlx.DB) (SessionStore, error) {
+	switch v := os.Getenv(sessionStoreEnvKey); v {
+	case "":
+		if db == nil {
+			log.Printf("no database is available. sessions are kept in memory and valid only on this node\n")
+			return newMemorySessionStore(), nil
+		}
+		return &mysqlSessionStore{db: db}, nil
+	case sessionStoreMemory:
+		log.Printf("environ %s is %s. sessions are valid only on this node\n", sessionStoreEnvKey, v)
+		return newMemorySessionStore(), nil
+	case sessionStoreMySQL:
+		return &mysqlSessionStore{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown session store '%s' in environment variable '%s'", v, sessionStoreEnvKey)
+	}
+}
+
+// sweepSessionsPeriodically は期限切れのセッションを定期的に消す
+func sweepSessionsPeriodically(ctx context.Context, store SessionStore) {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := store.DeleteExpired(ctx, now); err != nil && ctx.Err() == nil {
+				log.Printf("failed to delete expired sessions: %+v\n", err)
+			}
+		}
+	}
+}
+
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+	// byUser はユーザIDごとのセッションID
+	byUser map[int64]map[string]struct{}
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions: map[string]Session{},
+		byUser:   map[int64]map[string]struct{}{},
+	}
+}
+
+func (m *memorySessionStore) Create(_ context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	ids, ok := m.byUser[s.UserID]
+	if !ok {
+		ids = map[string]struct{}{}
+		m.byUser[s.UserID] = ids
+	}
+	ids[s.ID] = struct{}{}
+	return nil
+}
+
+func (m *memorySessionStore) Get(_ context.Context, id string) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, sql.ErrNoRows
+	}
+	return s, nil
+}
+
+func (m *memorySessionStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.delete(id)
+	return nil
+}
+
+func (m *memorySessionStore) Extend(_ context.Context, id string, expiresAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.ExpiresAt = expiresAt
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *memorySessionStore) delete(id string) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return
+	}
+	delete(m.sessions, id)
+	if ids, ok := m.byUser[s.UserID]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(m.byUser, s.UserID)
+		}
+	}
+}
+
+func (m *memorySessionStore) DeleteByUser(_ context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range m.byUser[userID] {
+		m.delete(id)
+	}
+	return nil
+}
+
+func (m *memorySessionStore) ListByUser(_ context.Context, userID int64) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now().Unix()
+	userSessions := []Session{}
+	for id := range m.byUser[userID] {
+		if s := m.sessions[id]; s.ExpiresAt >= now {
+			userSessions = append(userSessions, s)
+		}
+	}
+	sort.Slice(userSessions, func(i, j int) bool {
+		if userSessions[i].CreatedAt != userSessions[j].CreatedAt {
+			return userSessions[i].CreatedAt > userSessions[j].CreatedAt
+		}
+		return userSessions[i].ID < userSessions[j].ID
+	})
+	return userSessions, nil
+}
+
+func (m *memorySessionStore) DeleteExpired(_ context.Context, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.ExpiresAt < now.Unix() {
+			m.delete(id)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) Reset(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = map[string]Session{}
+	m.byUser = map[int64]map[string]struct{}{}
+	return nil
+}
+
+type mysqlSessionStore struct {
+	db *sqlx.DB
+}
+
+func (m *mysqlSessionStore) Create(ctx context.Context, s Session) error {
+	_, err := m.db.NamedExecContext(ctx,
+		"INSERT INTO sessions (id, user_id, user_agent, ip_address, created_at, expires_at) VALUES (:id, :user_id, :user_agent, :ip_address, :created_at, :expires_at)", s)
+	return err
+}
+
+func (m *mysqlSessionStore) Get(ctx context.Context, id string) (Session, error) {
+	var s Session
+	err := m.db.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id = ?", id)
+	return s, err
+}
+
+func (m *mysqlSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (m *mysqlSessionStore) Extend(ctx context.Context, id string, expiresAt int64) error {
+	_, err := m.db.ExecContext(ctx, "UPDATE sessions SET expires_at = ? WHERE id = ?", expiresAt, id)
+	return err
+}
+
+func (m *mysqlSessionStore) DeleteByUser(ctx context.Context, userID int64) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+func (m *mysqlSessionStore) ListByUser(ctx context.Context, userID int64) ([]Session, error) {
+	userSessions := []Session{}
+	err := m.db.SelectContext(ctx, &userSessions,
+		"SELECT * FROM sessions WHERE user_id = ? AND expires_at >= ? ORDER BY created_at DESC, id",
+		userID, time.Now().Unix())
+	return userSessions, err
+}
+
+func (m *mysqlSessionStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < ?", now.Unix())
+	return err
+}
+
+func (m *mysqlSessionStore) Reset(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "TRUNCATE TABLE sessions")
+	return err
+}
+
+// ログアウトAPI
+// POST /api/logout
+func logoutHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := sessionStore.Delete(ctx, principal.SessionID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session: "+err.Error())
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// ログイン時と同じ属性でクッキーを消す
+	sess.Options = sessionCfg.options(-1)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ログイン中のセッション一覧API
+// GET /api/user/me/sessions
+func getMySessionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	userSessions, err := sessionStore.ListByUser(ctx, principal.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get sessions: "+err.Error())
+	}
+
+	res := make([]SessionResponse, len(userSessions))
+	for i, s := range userSessions {
+		res[i] = SessionResponse{Session: s, Current: s.ID == principal.SessionID}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// セッション失効API
+// DELETE /api/user/me/sessions/:id
+func deleteMySessionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Param("id")
+	s, err := sessionStore.Get(ctx, id)
+	// 他のユーザのセッションは存在しないものとして扱う
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && s.UserID != principal.ID) {
+		return echo.NewHTTPError(http.StatusNotFound, "not found session")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get session: "+err.Error())
+	}
+
+	if err := sessionStore.Delete(ctx, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const testSessionID = "test-session"
+
+var testCookieStore = sessions.NewCookieStore([]byte("test-session-hash-key"))
+
+// useTestSessionStore はテストの間だけ sessionStore と sessionCfg を差し替える
+func useTestSessionStore(t *testing.T, cfg sessionConfig) *memorySessionStore {
+	t.Helper()
+
+	store, origStore, origCfg := newMemorySessionStore(), sessionStore, sessionCfg
+	sessionStore, sessionCfg = store, cfg
+	t.Cleanup(func() {
+		sessionStore, sessionCfg = origStore, origCfg
+	})
+	return store
+}
+
+// testSessionCookie はログイン時と同じ値を持つセッションのクッキー
+func testSessionCookie(t *testing.T, userID int64, expiresAt int64) *http.Cookie {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	sess, err := testCookieStore.Get(req, defaultSessionIDKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Options = sessionCfg.options(sessionCfg.maxAge())
+	sess.Values[defaultSessionIDKey] = testSessionID
+	sess.Values[defaultUserIDKey] = userID
+	sess.Values[defaultSessionExpiresKey] = expiresAt
+	if err := sess.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// savedSession は応答で書き直されたセッションのクッキーを読む。書き直していなければ nil
+func savedSession(t *testing.T, rec *httptest.ResponseRecorder) *sessions.Session {
+	t.Helper()
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		return nil
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	sess, err := testCookieStore.Get(req, defaultSessionIDKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+func TestSessionExpired(t *testing.T) {
+	const expiresAt = 1700000000
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{"before expiry", time.Unix(expiresAt-1, 0), false},
+		{"exactly at expiry", time.Unix(expiresAt, 0), false},
+		{"within the expiry second", time.Unix(expiresAt, int64(time.Second-1)), false},
+		{"after expiry", time.Unix(expiresAt+1, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionExpired(expiresAt, tt.now); got != tt.want {
+				t.Errorf("sessionExpired(%d, %v) = %v, want %v", expiresAt, tt.now.Unix(), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	cfg := sessionConfig{Lifetime: time.Hour}
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"just logged in", now.Add(time.Hour), false},
+		{"more than half left", now.Add(30*time.Minute + time.Second), false},
+		{"exactly half left", now.Add(30 * time.Minute), false},
+		{"less than half left", now.Add(30*time.Minute - time.Second), true},
+		{"exactly at expiry", now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.needsRenewal(tt.expiresAt.Unix(), now); got != tt.want {
+				t.Errorf("needsRenewal(now+%v) = %v, want %v", tt.expiresAt.Sub(now), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenewSession(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+	}{
+		{"default lifetime", time.Hour},
+		{"short lifetime", 90 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := sessionCfg
+			cfg.Lifetime = tt.lifetime
+			store := useTestSessionStore(t, cfg)
+			store.Create(context.Background(), Session{ID: testSessionID, UserID: 1, ExpiresAt: now.Add(time.Second).Unix()})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(testSessionCookie(t, 1, now.Add(time.Second).Unix()))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			sess, err := testCookieStore.Get(req, defaultSessionIDKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := renewSession(c, sess, testSessionID, now); err != nil {
+				t.Fatal(err)
+			}
+
+			want := now.Add(tt.lifetime).Unix()
+			if s, _ := store.Get(context.Background(), testSessionID); s.ExpiresAt != want {
+				t.Errorf("server ExpiresAt = %d, want %d", s.ExpiresAt, want)
+			}
+			saved := savedSession(t, rec)
+			if saved == nil {
+				t.Fatal("session cookie is not rewritten")
+			}
+			if got := saved.Values[defaultSessionExpiresKey]; got != want {
+				t.Errorf("cookie EXPIRES = %v, want %d", got, want)
+			}
+			if maxAge := rec.Result().Cookies()[0].MaxAge; maxAge != int(tt.lifetime/time.Second) {
+				t.Errorf("cookie Max-Age = %d, want %d", maxAge, int(tt.lifetime/time.Second))
+			}
+		})
+	}
+}
+
+func TestVerifyUserSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// cookieExpires と serverExpires は今からの期限。serverExpires が nil ならサーバ側にセッションがない
+		cookieExpires time.Duration
+		serverExpires *time.Duration
+		wantStatus    int
+		wantRenewed   bool
+	}{
+		{"just logged in", time.Hour, ptr(time.Hour), 0, false},
+		{"more than half left", 40 * time.Minute, ptr(40 * time.Minute), 0, false},
+		{"less than half left", 20 * time.Minute, ptr(20 * time.Minute), 0, true},
+		{"revoked", time.Hour, nil, http.StatusUnauthorized, false},
+		// クッキーの EXPIRES とサーバ側の ExpiresAt が食い違う場合はサーバ側に従う
+		{"cookie expired but renewed on the server", -time.Minute, ptr(50 * time.Minute), 0, false},
+		{"cookie expired and server needs renewal", -time.Minute, ptr(10 * time.Minute), 0, true},
+		{"server expired but cookie is valid", time.Hour, ptr(-time.Second), http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := useTestSessionStore(t, sessionCfg)
+			now := time.Now()
+			if tt.serverExpires != nil {
+				store.Create(context.Background(), Session{ID: testSessionID, UserID: 1, ExpiresAt: now.Add(*tt.serverExpires).Unix()})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(testSessionCookie(t, 1, now.Add(tt.cookieExpires).Unix()))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			err := session.Middleware(testCookieStore)(verifyUserSession)(c)
+			var he *echo.HTTPError
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Fatalf("verifyUserSession = %v, want success", err)
+			case tt.wantStatus != 0 && (!errors.As(err, &he) || he.Code != tt.wantStatus):
+				t.Fatalf("verifyUserSession = %v, want status %d", err, tt.wantStatus)
+			}
+
+			saved := savedSession(t, rec)
+			if renewed := saved != nil; renewed != tt.wantRenewed {
+				t.Fatalf("renewed = %v, want %v", renewed, tt.wantRenewed)
+			}
+			if !tt.wantRenewed {
+				return
+			}
+			s, _ := store.Get(context.Background(), testSessionID)
+			if s.ExpiresAt < now.Add(sessionCfg.Lifetime).Unix() {
+				t.Errorf("server ExpiresAt = now+%ds, want now+%v", s.ExpiresAt-now.Unix(), sessionCfg.Lifetime)
+			}
+			if got := saved.Values[defaultSessionExpiresKey]; got != s.ExpiresAt {
+				t.Errorf("cookie EXPIRES = %v, want %d", got, s.ExpiresAt)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -103,4 +103,16 @@ CREATE TABLE `reactions` (
   -- :innocent:, :tada:, etc...
   `emoji_name` VARCHAR(255) NOT NULL,
   `created_at` BIGINT NOT NULL
-) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
\ No newline at end of file
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ログイン中のセッション (ISUCON13_SESSION_STORE=memory でなければ使う)
+CREATE TABLE `sessions` (
+  `id` VARCHAR(36) NOT NULL PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `user_agent` VARCHAR(255) NOT NULL,
+  `ip_address` VARCHAR(64) NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  `expires_at` BIGINT NOT NULL,
+  INDEX `idx_user_id_expires_at` (`user_id`, `expires_at`),
+  INDEX `idx_expires_at` (`expires_at`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
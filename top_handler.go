@@ -43,12 +43,6 @@ func getTagHandler(c echo.Context) error {
 func getStreamerThemeHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
-		c.Logger().Printf("verifyUserSession: %+v\n", err)
-		return err
-	}
-
 	username := c.Param("username")
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
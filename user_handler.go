@@ -1,22 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/google/uuid"
-	"github.com/gorilla/sessions"
+	dnsserver "github.com/isucon/isucon13/webapp/go/dns"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
@@ -27,7 +25,6 @@ const (
 	defaultSessionExpiresKey = "EXPIRES"
 	defaultUserIDKey         = "USERID"
 	defaultUsernameKey       = "USERNAME"
-	bcryptDefaultCost        = bcrypt.MinCost
 )
 
 var fallbackImage = "../img/NoImage.jpg"
@@ -62,11 +59,13 @@ type ThemeModel struct {
 }
 
 type PostUserRequest struct {
-	Name        string `json:"name"`
-	DisplayName string `json:"display_name"`
-	Description string `json:"description"`
+	// Name は <name>.u.isucon.dev のサブドメインになる
+	Name        string `json:"name" validate:"required,dnslabel"`
+	DisplayName string `json:"display_name" validate:"max=255,nocontrol"`
+	Description string `json:"description" validate:"maxbytes=65535"`
 	// Password is non-hashed password.
-	Password string               `json:"password"`
+	// bcrypt は72バイトまでしか使わない
+	Password string               `json:"password" validate:"required,maxbytes=72"`
 	Theme    PostUserRequestTheme `json:"theme"`
 }
 
@@ -75,13 +74,28 @@ type PostUserRequestTheme struct {
 }
 
 type LoginRequest struct {
-	Username string `json:"username"`
+	Username string `json:"username" validate:"required,max=255"`
 	// Password is non-hashed password.
-	Password string `json:"password"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
+}
+
+type PatchUserRequest struct {
+	// 指定されなかった項目は変更しない
+	DisplayName *string `json:"display_name" validate:"notblank,max=255,nocontrol"`
+	Description *string `json:"description" validate:"maxbytes=65535"`
+}
+
+type PutThemeRequest struct {
+	DarkMode *bool `json:"dark_mode" validate:"required"`
+}
+
+type DeleteUserRequest struct {
+	// Password は本人確認のためのパスワード
+	Password string `json:"password" validate:"required"`
 }
 
 type PostIconRequest struct {
-	Image []byte `json:"image"`
+	Image []byte `json:"image" validate:"required"`
 }
 
 type PostIconResponse struct {
@@ -112,37 +126,64 @@ func getIconHandler(c echo.Context) error {
 		return c.NoContent(http.StatusNotModified)
 	}
 
+	size, err := parseIconSize(c.QueryParam("size"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	// iconがディレクトリに存在するか確認
-	if _, err := os.Stat(fmt.Sprintf("%s/%d", iconDir, cachedUser.ID)); err != nil {
-		if os.IsNotExist(err) {
-			return c.File(fallbackImage)
-		} else {
+	path := fmt.Sprintf("%s/%d", iconDir, cachedUser.ID)
+	thumbnailPrefix := strconv.FormatInt(cachedUser.ID, 10)
+	if _, err := os.Stat(path); err != nil {
+		if !os.IsNotExist(err) {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user icon: "+err.Error())
 		}
+		path = fallbackImage
+		thumbnailPrefix = "fallback"
+	}
+
+	if size > 0 {
+		path, err = iconThumbnail(path, thumbnailPrefix, size)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to make icon thumbnail: "+err.Error())
+		}
 	}
+
 	// 画像を返す
-	// Content-Type: image/jpeg を設定する
-	c.Response().Header().Set("Content-Type", "image/jpeg")
-	return c.File(fmt.Sprintf("%s/%d", iconDir, cachedUser.ID))
+	// ファイル名に拡張子がないので、Content-Type は c.File が先頭のバイト列から判定する
+	return c.File(path)
 }
 
 func postIconHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
+	principal, err := currentUser(c)
+	if err != nil {
 		return err
 	}
+	userID := principal.ID
 
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
-
+	// 画像は base64 で送られてくるので、JSON は画像の上限の 4/3 倍と少しまで読む
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, int64(iconCfg.MaxBytes)/3*4+4096)
 	var req *PostIconRequest
-	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("image must be at most %d bytes", iconCfg.MaxBytes))
+		}
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+	if len(req.Image) > iconCfg.MaxBytes {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("image must be at most %d bytes", iconCfg.MaxBytes))
+	}
+
+	image, err := iconCfg.processIcon(req.Image)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid image: "+err.Error())
+	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -157,12 +198,15 @@ func postIconHandler(c echo.Context) error {
 
 	// 画像をファイルに書き出す
 	imageFilePath := fmt.Sprintf("%s/%d", iconDir, userID)
-	err = os.WriteFile(imageFilePath, req.Image, 0644)
+	err = writeFileAtomic(imageFilePath, image)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to write image file: "+err.Error())
 	}
+	if err := removeIconThumbnails(userID); err != nil {
+		c.Logger().Errorf("failed to remove icon thumbnails of user %d: %+v", userID, err)
+	}
 
-	iconHash := sha256.Sum256(req.Image)
+	iconHash := sha256.Sum256(image)
 	_, err = tx.ExecContext(ctx, "UPDATE users SET icon_hash = ? WHERE id = ?", fmt.Sprintf("%x", iconHash), userID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update icon hash: "+err.Error())
@@ -185,15 +229,11 @@ func postIconHandler(c echo.Context) error {
 func getMeHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
+	principal, err := currentUser(c)
+	if err != nil {
 		return err
 	}
-
-	// error already checked
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	// existence already checked
-	userID := sess.Values[defaultUserIDKey].(int64)
+	userID := principal.ID
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -216,49 +256,259 @@ func getMeHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
-// ユーザ登録API
-// POST /api/register
-func registerHandler(c echo.Context) error {
+// invalidateUserCache は変更したユーザをキャッシュから消し、次の応答で読み直させる
+func invalidateUserCache(userID int64, name string) {
+	userCache.Delete(userID)
+	userNameCache.Delete(name)
+}
+
+// プロフィール編集API
+// PATCH /api/user/me
+func patchMeHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	defer c.Request().Body.Close()
 
-	req := PostUserRequest{}
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	req := PatchUserRequest{}
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ? FOR UPDATE", principal.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	if req.DisplayName != nil {
+		userModel.DisplayName = *req.DisplayName
+	}
+	if req.Description != nil {
+		userModel.Description = *req.Description
+	}
+	if _, err := tx.NamedExecContext(ctx, "UPDATE users SET display_name = :display_name, description = :description WHERE id = :id", userModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user: "+err.Error())
+	}
+
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+	}
 
-	if req.Name == "pipe" {
-		return echo.NewHTTPError(http.StatusBadRequest, "the username 'pipe' is reserved")
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	invalidateUserCache(userModel.ID, userModel.Name)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// テーマ変更API
+// PUT /api/user/me/theme
+func putMyThemeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	principal, err := currentUser(c)
+	if err != nil {
+		return err
 	}
 
-	// BcryptをAPIに投げる
-	api := fmt.Sprintf("%s/sum", bcryptAPI)
-	breq := PostBcryptSumHandler{
-		Password: req.Password,
+	req := PutThemeRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validateRequest(&req); err != nil {
+		return err
 	}
-	breqJson, err := json.Marshal(breq)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to marshal json: "+err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE themes SET dark_mode = ? WHERE user_id = ?", *req.DarkMode, principal.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user theme: "+err.Error())
+	}
+
+	themeModel := ThemeModel{}
+	if err := tx.GetContext(ctx, &themeModel, "SELECT * FROM themes WHERE user_id = ?", principal.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user theme")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user theme: "+err.Error())
 	}
 
-	// apiに投げる
-	bres, err := http.Post(api, "application/json", bytes.NewBuffer(breqJson))
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	invalidateUserCache(principal.ID, principal.Name)
+
+	return c.JSON(http.StatusOK, Theme{
+		ID:       themeModel.ID,
+		DarkMode: themeModel.DarkMode,
+	})
+}
+
+// 退会API
+// DELETE /api/user/me
+// ユーザと、ユーザの配信・コメント・リアクションなどをすべて消す
+func deleteMeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	principal, err := currentUser(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to post to bcrypt api: "+err.Error())
+		return err
 	}
-	bresBody, err := io.ReadAll(bres.Body)
+
+	req := DeleteUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+
+	// パスワードの確認はログインと同じく総当たりを防ぐ
+	now := time.Now()
+	clientIP := c.RealIP()
+	if wait := loginThrottler.check(principal.Name, clientIP, now); wait > 0 {
+		return loginLockedError(c, wait)
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to read response body: "+err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ? FOR UPDATE", principal.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	if err := passwordHasher.Verify(ctx, userModel.HashedPassword, req.Password); err != nil {
+		if errors.Is(err, errPasswordMismatch) {
+			loginThrottler.fail(principal.Name, clientIP, now)
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid password")
+		}
+		if errors.Is(err, errBcryptQueueFull) {
+			return bcryptUnavailableError(c)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
+	}
+
+	var livestreamModels []*LivestreamModel
+	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", userModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+	}
+	// 予約していた枠を空ける
+	for _, livestreamModel := range livestreamModels {
+		if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + 1 WHERE start_at >= ? AND end_at <= ?", livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+		}
+	}
+
+	// 配信に付いたものから順に消す
+	for _, q := range []struct {
+		table string
+		query string
+		args  []any
+	}{
+		{"livecomment_reports", "DELETE FROM livecomment_reports WHERE user_id = ? OR livecomment_id IN (SELECT id FROM livecomments WHERE user_id = ?) OR livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID, userModel.ID, userModel.ID}},
+		{"livestream_tags", "DELETE FROM livestream_tags WHERE livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID}},
+		{"ng_words", "DELETE FROM ng_words WHERE user_id = ? OR livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID, userModel.ID}},
+		{"reactions", "DELETE FROM reactions WHERE user_id = ? OR livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID, userModel.ID}},
+		{"livecomments", "DELETE FROM livecomments WHERE user_id = ? OR livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID, userModel.ID}},
+		{"livestream_viewers_history", "DELETE FROM livestream_viewers_history WHERE user_id = ? OR livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?)", []any{userModel.ID, userModel.ID}},
+		{"livestreams", "DELETE FROM livestreams WHERE user_id = ?", []any{userModel.ID}},
+		{"icons", "DELETE FROM icons WHERE user_id = ?", []any{userModel.ID}},
+		{"themes", "DELETE FROM themes WHERE user_id = ?", []any{userModel.ID}},
+		{"users", "DELETE FROM users WHERE id = ?", []any{userModel.ID}},
+	} {
+		if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete "+q.table+": "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	// ここから先はDBから消えた後の後始末なので、失敗してもログに残すだけにする
+	if err := os.Remove(fmt.Sprintf("%s/%d", iconDir, userModel.ID)); err != nil && !os.IsNotExist(err) {
+		c.Logger().Errorf("failed to remove icon of user %d: %+v", userModel.ID, err)
+	}
+	if err := removeIconThumbnails(userModel.ID); err != nil {
+		c.Logger().Errorf("failed to remove icon thumbnails of user %d: %+v", userModel.ID, err)
+	}
+	if _, err := dnsStore.Delete(strings.ToLower(userModel.Name)); err != nil {
+		c.Logger().Errorf("failed to delete dns record of user %d: %+v", userModel.ID, err)
+	}
+	if err := sessionStore.DeleteByUser(ctx, userModel.ID); err != nil {
+		c.Logger().Errorf("failed to delete sessions of user %d: %+v", userModel.ID, err)
+	}
+	loginThrottler.clear(userModel.Name, "")
+	invalidateUserCache(userModel.ID, userModel.Name)
+	for _, livestreamModel := range livestreamModels {
+		livestreamCache.Delete(livestreamModel.ID)
+		livestreamTagsCache.Delete(livestreamModel.ID)
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	sess.Options = sessionCfg.options(-1)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ユーザ登録API
+// POST /api/register
+func registerHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	req := PostUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validateRequest(&req); err != nil {
+		return err
 	}
 
-	if bres.StatusCode != http.StatusOK {
-		bResStr := string(bresBody)
-		return echo.NewHTTPError(bres.StatusCode, bResStr)
+	if reservedNames.isReserved(req.Name) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("the username '%s' is reserved", req.Name))
 	}
 
-	var bresJson PostBcryptSumResult
-	if err := json.Unmarshal(bresBody, &bresJson); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to unmarshal json: "+err.Error())
+	hashedPassword, err := passwordHasher.Hash(ctx, req.Password)
+	if errors.Is(err, errBcryptQueueFull) {
+		return bcryptUnavailableError(c)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
 	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
@@ -271,7 +521,7 @@ func registerHandler(c echo.Context) error {
 		Name:           req.Name,
 		DisplayName:    req.DisplayName,
 		Description:    req.Description,
-		HashedPassword: bresJson.HashedPassword,
+		HashedPassword: hashedPassword,
 	}
 
 	result, err := tx.NamedExecContext(ctx, "INSERT INTO users (name, display_name, description, password) VALUES(:name, :display_name, :description, :password)", userModel)
@@ -294,11 +544,6 @@ func registerHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user theme: "+err.Error())
 	}
 
-	// if out, err := exec.Command("pdnsutil", "add-record", "u.isucon.dev", req.Name, "A", "0", powerDNSSubdomainAddress).CombinedOutput(); err != nil {
-	// 	return echo.NewHTTPError(http.StatusInternalServerError, string(out)+": "+err.Error())
-	// }
-	dnsRecordMap.Store(req.Name, struct{}{})
-
 	user, err := fillUserResponse(ctx, tx, userModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
@@ -308,6 +553,17 @@ func registerHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	// if out, err := exec.Command("pdnsutil", "add-record", "u.isucon.dev", req.Name, "A", "0", powerDNSSubdomainAddress).CombinedOutput(); err != nil {
+	// 	return echo.NewHTTPError(http.StatusInternalServerError, string(out)+": "+err.Error())
+	// }
+	// コミットに失敗した登録のサブドメインが引けないよう、レコードはコミット後に追加する
+	// ユーザは登録済みなので、失敗してもエラーにせず裏で追加し直す
+	// 管理用APIで同じ名前にアドレスを設定してあれば、そのまま残す
+	if _, err := dnsStore.Add(strings.ToLower(req.Name), dnsserver.Record{}); err != nil {
+		c.Logger().Errorf("failed to add dns record of user %s, retrying: %+v", req.Name, err)
+		go retryPutUserDNSRecord(strings.ToLower(req.Name))
+	}
+
 	return c.JSON(http.StatusCreated, user)
 }
 
@@ -321,6 +577,16 @@ func loginHandler(c echo.Context) error {
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
+	if err := validateRequest(&req); err != nil {
+		return err
+	}
+
+	// 失敗が続いているユーザ名・送信元IPはパスワードを照合しない
+	now := time.Now()
+	clientIP := c.RealIP()
+	if wait := loginThrottler.check(req.Username, clientIP, now); wait > 0 {
+		return loginLockedError(c, wait)
+	}
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
@@ -332,6 +598,7 @@ func loginHandler(c echo.Context) error {
 	// usernameはUNIQUEなので、whereで一意に特定できる
 	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", req.Username)
 	if errors.Is(err, sql.ErrNoRows) {
+		loginThrottler.fail(req.Username, clientIP, now)
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
 	}
 	if err != nil {
@@ -342,33 +609,23 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
-	// BcryptをAPIに投げる
-	api := fmt.Sprintf("%s/compair", bcryptAPI)
-	breq := PostBcryptCompairHandler{
-		Password:       req.Password,
-		HashedPassword: userModel.HashedPassword,
-	}
-	breqJson, err := json.Marshal(breq)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to marshal json: "+err.Error())
-	}
-
-	// apiに投げる
-	bres, err := http.Post(api, "application/json", bytes.NewBuffer(breqJson))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to post to bcrypt api: "+err.Error())
-	}
-	bresBody, err := io.ReadAll(bres.Body)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to read response body: "+err.Error())
+	if err := passwordHasher.Verify(ctx, userModel.HashedPassword, req.Password); err != nil {
+		if errors.Is(err, errPasswordMismatch) {
+			loginThrottler.fail(req.Username, clientIP, now)
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+		}
+		if errors.Is(err, errBcryptQueueFull) {
+			return bcryptUnavailableError(c)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
 	}
-
-	if bres.StatusCode != http.StatusOK {
-		bResStr := string(bresBody)
-		return echo.NewHTTPError(bres.StatusCode, bResStr)
+	loginThrottler.succeed(req.Username, clientIP)
+	if passwordHasher.allowRehash(userModel.HashedPassword) {
+		// ログインの応答を遅らせないよう、ハッシュの置き換えは後で行う
+		go rehashPassword(userModel.ID, userModel.HashedPassword, req.Password)
 	}
 
-	sessionEndAt := time.Now().Add(1 * time.Hour)
+	sessionEndAt := time.Now().Add(sessionCfg.Lifetime)
 
 	sessionID := uuid.NewString()
 
@@ -377,16 +634,23 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get session")
 	}
 
-	sess.Options = &sessions.Options{
-		Domain: "u.isucon.dev",
-		MaxAge: int(60000),
-		Path:   "/",
-	}
+	sess.Options = sessionCfg.options(sessionCfg.maxAge())
 	sess.Values[defaultSessionIDKey] = sessionID
 	sess.Values[defaultUserIDKey] = userModel.ID
 	sess.Values[defaultUsernameKey] = userModel.Name
 	sess.Values[defaultSessionExpiresKey] = sessionEndAt.Unix()
 
+	if err := sessionStore.Create(ctx, Session{
+		ID:        sessionID,
+		UserID:    userModel.ID,
+		UserAgent: truncateUTF8(c.Request().UserAgent(), sessionUserAgentMaxBytes),
+		IPAddress: clientIP,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: sessionEndAt.Unix(),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create session: "+err.Error())
+	}
+
 	if err := sess.Save(c.Request(), c.Response()); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
 	}
@@ -398,11 +662,6 @@ func loginHandler(c echo.Context) error {
 // GET /api/user/:username
 func getUserHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
-		// echo.NewHTTPErrorが返っているのでそのまま出力
-		return err
-	}
-
 	username := c.Param("username")
 
 	tx, err := dbConn.BeginTxx(ctx, nil)
@@ -437,21 +696,41 @@ func verifyUserSession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get session")
 	}
 
-	sessionExpires, ok := sess.Values[defaultSessionExpiresKey]
-	if !ok {
+	// 期限はサーバ側のセッションで判断する
+	// 他のリクエストで延長したクッキーが届いていなければ、クッキーの EXPIRES は古いまま
+	if _, ok := sess.Values[defaultSessionExpiresKey]; !ok {
 		return echo.NewHTTPError(http.StatusForbidden, "failed to get EXPIRES value from session")
 	}
 
-	_, ok = sess.Values[defaultUserIDKey].(int64)
+	userID, ok := sess.Values[defaultUserIDKey].(int64)
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get USERID value from session")
 	}
 
+	// ログアウトや失効で消されたセッションは使えない
+	sessionID, ok := sess.Values[defaultSessionIDKey].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get SESSIONID value from session")
+	}
+	serverSession, err := sessionStore.Get(c.Request().Context(), sessionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && serverSession.UserID != userID) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get session: "+err.Error())
+	}
 	now := time.Now()
-	if now.Unix() > sessionExpires.(int64) {
+	if sessionExpired(serverSession.ExpiresAt, now) {
 		return echo.NewHTTPError(http.StatusUnauthorized, "session has expired")
 	}
 
+	// 使われているセッションは期限を延ばす
+	if sessionCfg.needsRenewal(serverSession.ExpiresAt, now) {
+		if err := renewSession(c, sess, sessionID, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to renew session: "+err.Error())
+		}
+	}
+
 	return nil
 }
 
@@ -471,8 +750,13 @@ func getUserResponse(ctx context.Context, tx *sqlx.Tx, id int64) (User, error) {
 		return fetched.user, nil
 	}
 
+	// tx が nil ならトランザクションの外で読む
+	var db sqlx.QueryerContext = dbConn
+	if tx != nil {
+		db = tx
+	}
 	model := UserModel{}
-	if err := tx.GetContext(ctx, &model, "SELECT * FROM users WHERE id = ?", id); err != nil {
+	if err := sqlx.GetContext(ctx, db, &model, "SELECT * FROM users WHERE id = ?", id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, sql.ErrNoRows
 		}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// リクエストの検証
+// リクエストの構造体のフィールドに `validate:"required,max=255"` のようにルールを書き、validateRequest で検証する
+// 失敗したフィールドをすべて集めて 400 で返す
+//
+// ルール (カンマ区切り、空文字列・nil のフィールドは required と notblank 以外のルールを飛ばす)
+//
+//	required   ゼロ値・nil でない
+//	notblank   空白だけの文字列でない
+//	min=N      文字列なら文字数、数値なら値、スライスなら要素数の下限
+//	max=N      同じく上限
+//	maxbytes=N 文字列のバイト数の上限
+//	nocontrol  制御文字を含まない
+//	dnslabel   DNS のラベルとして使える (英小文字・数字・ハイフン、63文字まで)
+//	url        http か https の絶対URL
+//	ip         IPアドレス
+//	tags       すべての要素が globalTags のタグID
+
+var dnsLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var validationRuleNames = map[string]bool{
+	"required": true, "notblank": true, "min": true, "max": true, "maxbytes": true,
+	"nocontrol": true, "dnslabel": true, "url": true, "ip": true, "tags": true,
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError は errorResponseHandler が 400 とフィールドごとのエラーで返す
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid request: " + strings.Join(msgs, ", ")
+}
+
+type validationRule struct {
+	name  string
+	param int64
+}
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []validationRule
+}
+
+// 構造体ごとのルールはタグを解析した結果をキャッシュする
+var validationRulesCache sync.Map
+
+func rulesOf(t reflect.Type) []fieldRules {
+	if v, ok := validationRulesCache.Load(t); ok {
+		return v.([]fieldRules)
+	}
+
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+
+		var rules []validationRule
+		for _, r := range strings.Split(tag, ",") {
+			rule := validationRule{name: r}
+			if k, v, ok := strings.Cut(r, "="); ok {
+				param, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					panic(fmt.Sprintf("invalid validate tag on %s.%s: %s", t.Name(), f.Name, r))
+				}
+				rule = validationRule{name: k, param: param}
+			}
+			if !validationRuleNames[rule.name] {
+				panic(fmt.Sprintf("unknown validation rule on %s.%s: %s", t.Name(), f.Name, r))
+			}
+			rules = append(rules, rule)
+		}
+		fields = append(fields, fieldRules{index: i, name: name, rules: rules})
+	}
+
+	validationRulesCache.Store(t, fields)
+	return fields
+}
+
+// validateRequest は req (構造体かそのポインタ) を検証し、失敗すれば *ValidationError を返す
+func validateRequest(req any) error {
+	v := reflect.ValueOf(req)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return &ValidationError{Fields: []FieldError{{Field: "body", Message: "request body is required"}}}
+		}
+		v = v.Elem()
+	}
+
+	var errs []FieldError
+	for _, f := range rulesOf(v.Type()) {
+		if msg := validateField(v.Field(f.index), f.rules); msg != "" {
+			errs = append(errs, FieldError{Field: f.name, Message: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// validateField は最初に失敗したルールのメッセージを返す
+func validateField(v reflect.Value, rules []validationRule) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			for _, r := range rules {
+				if r.name == "required" {
+					return "is required"
+				}
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	for _, r := range rules {
+		switch r.name {
+		case "required":
+			if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+				return "is required"
+			}
+		case "notblank":
+			if v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "" {
+				return "must not be blank"
+			}
+		default:
+			if (v.Kind() == reflect.String || v.Kind() == reflect.Slice) && v.Len() == 0 {
+				continue
+			}
+			if msg := applyRule(v, r); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+func applyRule(v reflect.Value, r validationRule) string {
+	switch r.name {
+	case "min", "max":
+		var n int64
+		var unit string
+		switch v.Kind() {
+		case reflect.String:
+			n, unit = int64(utf8.RuneCountInString(v.String())), " characters"
+		case reflect.Slice:
+			n, unit = int64(v.Len()), " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = v.Int()
+		default:
+			return ""
+		}
+		if r.name == "min" && n < r.param {
+			return fmt.Sprintf("must be at least %d%s", r.param, unit)
+		}
+		if r.name == "max" && n > r.param {
+			return fmt.Sprintf("must be at most %d%s", r.param, unit)
+		}
+	case "maxbytes":
+		if int64(len(v.String())) > r.param {
+			return fmt.Sprintf("must be at most %d bytes", r.param)
+		}
+	case "nocontrol":
+		if !utf8.ValidString(v.String()) || strings.IndexFunc(v.String(), unicode.IsControl) >= 0 {
+			return "must not contain control characters"
+		}
+	case "dnslabel":
+		if !dnsLabelRegexp.MatchString(v.String()) {
+			return "must consist of lowercase letters, digits and hyphens, and must not start or end with a hyphen"
+		}
+	case "url":
+		u, err := url.Parse(v.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "must be an http or https URL"
+		}
+	case "ip":
+		if net.ParseIP(v.String()) == nil {
+			return "must be an IP address"
+		}
+	case "tags":
+		for i := 0; i < v.Len(); i++ {
+			// globalTags[0] は空文字列で、タグIDは1から
+			if id := v.Index(i).Int(); id < 1 || id >= int64(len(globalTags)) {
+				return fmt.Sprintf("contains unknown tag id %d", id)
+			}
+		}
+	}
+	return ""
+}